@@ -0,0 +1,691 @@
+package gamebert
+
+import "math"
+
+// Audio Processing Unit
+// https://gbdev.io/pandocs/Audio.html
+// https://gbdev.gg8.se/wiki/articles/Gameboy_sound_hardware
+
+const (
+	DefaultSampleRate = 44100
+
+	// The frame sequencer is clocked by the falling edge of this bit of DIV,
+	// giving 512Hz.
+	apuDivBit = 4
+)
+
+type APU struct {
+	enabled bool // NR52 bit 7
+
+	ch1 *squareChannel
+	ch2 *squareChannel
+	ch3 *waveChannel
+	ch4 *noiseChannel
+
+	nr50 uint8 // 0xFF24 - master volume
+	nr51 uint8 // 0xFF25 - panning
+
+	frameSequencerStep uint8
+	lastDivBit         bool
+
+	sampleRate int
+	// Multiplies the cycles per sample, for emulation speed and rate control
+	rateScale       float64
+	cyclesPerSample float64
+	sampleCounter   float64
+	// Interleaved stereo samples (left, right, left, ...) waiting to be
+	// collected with TakeSamples. At most a second's worth is kept, so
+	// nothing piles up if nobody is listening.
+	samples []int16
+
+	// The output capacitors, which block the DACs' DC offset. There's one
+	// for each side of each channel, rather than one per side after
+	// mixing, so that captured channels still add up to the mix.
+	highPass [4][2]highPassFilter
+	// How much of the capacitors' charge is left after each sample
+	highPassCharge float64
+
+	// Channels (numbered from 0) that are left out of samples
+	channelMuted [4]bool
+	// When set, each channel is also recorded on its own, whether or not
+	// it's muted. The four add up to samples when nothing is muted.
+	captureChannels bool
+	channelSamples  [4][]int16
+}
+
+func newAPU(sampleRate int) *APU {
+	apu := &APU{
+		ch1:       &squareChannel{hasSweep: true},
+		ch2:       &squareChannel{},
+		ch3:       &waveChannel{},
+		ch4:       &noiseChannel{},
+		rateScale: 1,
+	}
+	apu.setSampleRate(sampleRate)
+	return apu
+}
+
+func (apu *APU) setSampleRate(sampleRate int) {
+	apu.sampleRate = sampleRate
+	apu.cyclesPerSample = float64(cyclesPerSecond) / float64(sampleRate) * apu.rateScale
+	apu.highPassCharge = math.Pow(highPassChargePerCycle, apu.cyclesPerSample)
+}
+
+// SetRateScale stretches the time between samples. Running at double speed
+// with a scale of 2 keeps the number of samples per real second the same.
+func (apu *APU) SetRateScale(scale float64) {
+	apu.rateScale = scale
+	apu.setSampleRate(apu.sampleRate)
+}
+
+// tick advances the APU by a number of cycles. div is the current value of
+// the DIV register, which drives the frame sequencer.
+func (apu *APU) tick(cycles uint8, div uint8) {
+	divBit := isBitSet8(div, apuDivBit)
+	if apu.enabled && apu.lastDivBit && !divBit {
+		apu.clockFrameSequencer()
+	}
+	apu.lastDivBit = divBit
+
+	if apu.enabled {
+		apu.ch1.tick(cycles)
+		apu.ch2.tick(cycles)
+		apu.ch3.tick(cycles)
+		apu.ch4.tick(cycles)
+	}
+
+	apu.sampleCounter += float64(cycles)
+	for apu.sampleCounter >= apu.cyclesPerSample {
+		apu.sampleCounter -= apu.cyclesPerSample
+		if len(apu.samples) < 2*apu.sampleRate {
+			channels := apu.mixChannels()
+			left, right := apu.mix(channels)
+			apu.samples = append(apu.samples, left, right)
+
+			if apu.captureChannels {
+				for i, ch := range channels {
+					apu.channelSamples[i] = append(apu.channelSamples[i], ch[0], ch[1])
+				}
+			}
+		}
+	}
+}
+
+// The frame sequencer clocks length counters at 256Hz, sweep at 128Hz and
+// envelopes at 64Hz.
+func (apu *APU) clockFrameSequencer() {
+	step := apu.frameSequencerStep
+	if step%2 == 0 {
+		apu.ch1.length.clock(&apu.ch1.enabled)
+		apu.ch2.length.clock(&apu.ch2.enabled)
+		apu.ch3.length.clock(&apu.ch3.enabled)
+		apu.ch4.length.clock(&apu.ch4.enabled)
+	}
+	if step == 2 || step == 6 {
+		apu.ch1.clockSweep()
+	}
+	if step == 7 {
+		apu.ch1.envelope.clock()
+		apu.ch2.envelope.clock()
+		apu.ch4.envelope.clock()
+	}
+	apu.frameSequencerStep = (step + 1) % 8
+}
+
+// mixChannels works out each channel's stereo output after NR51 panning
+// and NR50 master volume.
+func (apu *APU) mixChannels() [4][2]int16 {
+	var mixed [4][2]int16
+	if !apu.enabled {
+		return mixed
+	}
+
+	outputs := [4]float64{
+		dac(apu.ch1.output(), apu.ch1.dacEnabled()),
+		dac(apu.ch2.output(), apu.ch2.dacEnabled()),
+		dac(apu.ch3.output(), apu.ch3.dacEnabled()),
+		dac(apu.ch4.output(), apu.ch4.dacEnabled()),
+	}
+
+	leftVol := float64((apu.nr50>>4)&0b111+1) / 8
+	rightVol := float64(apu.nr50&0b111+1) / 8
+
+	for i, out := range outputs {
+		var left, right float64
+		if isBitSet8(apu.nr51, uint8(i+4)) {
+			left = out * leftVol
+		}
+		if isBitSet8(apu.nr51, uint8(i)) {
+			right = out * rightVol
+		}
+		mixed[i][0] = apu.highPass[i][0].sample(left, apu.highPassCharge)
+		mixed[i][1] = apu.highPass[i][1].sample(right, apu.highPassCharge)
+	}
+	return mixed
+}
+
+// The DMG's output capacitors keep this much of their charge each cycle
+// https://gbdev.io/pandocs/Audio_details.html#obscure-behavior
+const highPassChargePerCycle = 0.999958
+
+// highPassFilter is an output capacitor. The DACs output 0 as +1, so
+// without it everything sits at a large DC offset that pops whenever a
+// DAC is switched on or off.
+type highPassFilter struct {
+	capacitor float64
+}
+
+// sample filters one of a channel's outputs, in [-1, 1], and scales it to a
+// quarter of the int16 range, so that four channels add up without
+// overflowing
+func (f *highPassFilter) sample(in float64, charge float64) int16 {
+	out := in - f.capacitor
+	f.capacitor = in - out*charge
+
+	// A sudden swing can briefly take the output past the rails
+	out = math.Max(-1, math.Min(1, out))
+	return int16(out * float64(0x7FFF) / 4)
+}
+
+// mix adds up the channels that aren't muted
+func (apu *APU) mix(channels [4][2]int16) (int16, int16) {
+	var left, right int16
+	for i, ch := range channels {
+		if !apu.channelMuted[i] {
+			left += ch[0]
+			right += ch[1]
+		}
+	}
+	return left, right
+}
+
+// dac converts a channel's 4-bit digital output into [-1, 1]
+func dac(digital uint8, enabled bool) float64 {
+	if !enabled {
+		return 0
+	}
+	return 1 - float64(digital)/7.5
+}
+
+// TakeSamples returns and clears the samples generated since the last call
+func (apu *APU) TakeSamples() []int16 {
+	samples := apu.samples
+	apu.samples = nil
+	return samples
+}
+
+// TakeChannelSamples is TakeSamples for a single channel, numbered from 0,
+// while SetCaptureChannels is on.
+func (apu *APU) TakeChannelSamples(ch int) []int16 {
+	samples := apu.channelSamples[ch]
+	apu.channelSamples[ch] = nil
+	return samples
+}
+
+// ToggleChannel mutes or unmutes a channel, numbered from 0, and returns
+// whether it's now muted.
+func (apu *APU) ToggleChannel(ch int) bool {
+	apu.channelMuted[ch] = !apu.channelMuted[ch]
+	return apu.channelMuted[ch]
+}
+
+// SetCaptureChannels turns on keeping each channel's samples separately,
+// as well as the mix
+func (apu *APU) SetCaptureChannels(capture bool) {
+	apu.captureChannels = capture
+}
+
+// SetMutedChannels leaves channels out of the mix, indexed from 0
+func (apu *APU) SetMutedChannels(muted [4]bool) {
+	apu.channelMuted = muted
+}
+
+// MutedChannels reports which channels are left out of the mix
+func (apu *APU) MutedChannels() [4]bool {
+	return apu.channelMuted
+}
+
+// Bits that always read back as 1, for 0xFF10-0xFF2F
+var apuReadMasks = [0x20]uint8{
+	0x80, 0x3F, 0x00, 0xFF, 0xBF, // NR10-NR14
+	0xFF, 0x3F, 0x00, 0xFF, 0xBF, // NR20-NR24
+	0x7F, 0xFF, 0x9F, 0xFF, 0xBF, // NR30-NR34
+	0xFF, 0xFF, 0x00, 0x00, 0xBF, // NR40-NR44
+	0x00, 0x00, 0x70, // NR50-NR52
+	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+}
+
+func (apu *APU) readByte(loc uint16) uint8 {
+	if loc >= 0xFF30 {
+		return apu.ch3.waveRAM[loc-0xFF30]
+	}
+
+	var val uint8
+	switch loc {
+	case 0xFF10, 0xFF11, 0xFF12, 0xFF13, 0xFF14:
+		val = apu.ch1.regs[loc-0xFF10]
+	case 0xFF16, 0xFF17, 0xFF18, 0xFF19:
+		val = apu.ch2.regs[loc-0xFF15]
+	case 0xFF1A, 0xFF1B, 0xFF1C, 0xFF1D, 0xFF1E:
+		val = apu.ch3.regs[loc-0xFF1A]
+	case 0xFF20, 0xFF21, 0xFF22, 0xFF23:
+		val = apu.ch4.regs[loc-0xFF1F]
+	case 0xFF24:
+		val = apu.nr50
+	case 0xFF25:
+		val = apu.nr51
+	case 0xFF26:
+		if apu.enabled {
+			val |= 1 << 7
+		}
+		for i, on := range []bool{apu.ch1.enabled, apu.ch2.enabled, apu.ch3.enabled, apu.ch4.enabled} {
+			if on {
+				val |= 1 << i
+			}
+		}
+	}
+	return val | apuReadMasks[loc-0xFF10]
+}
+
+func (apu *APU) writeByte(loc uint16, val uint8) {
+	if loc >= 0xFF30 {
+		apu.ch3.waveRAM[loc-0xFF30] = val
+		return
+	}
+
+	if loc == 0xFF26 {
+		apu.setPower(isBitSet8(val, 7))
+		return
+	}
+
+	// Registers are read-only while the APU is off
+	if !apu.enabled {
+		return
+	}
+
+	switch {
+	case loc >= 0xFF10 && loc <= 0xFF14:
+		apu.ch1.write(uint8(loc-0xFF10), val)
+	case loc >= 0xFF16 && loc <= 0xFF19:
+		apu.ch2.write(uint8(loc-0xFF15), val)
+	case loc >= 0xFF1A && loc <= 0xFF1E:
+		apu.ch3.write(uint8(loc-0xFF1A), val)
+	case loc >= 0xFF20 && loc <= 0xFF23:
+		apu.ch4.write(uint8(loc-0xFF1F), val)
+	case loc == 0xFF24:
+		apu.nr50 = val
+	case loc == 0xFF25:
+		apu.nr51 = val
+	}
+}
+
+func (apu *APU) setPower(on bool) {
+	if on == apu.enabled {
+		return
+	}
+
+	if !on {
+		// Powering off clears every register except wave RAM
+		waveRAM := apu.ch3.waveRAM
+		apu.ch1 = &squareChannel{hasSweep: true}
+		apu.ch2 = &squareChannel{}
+		apu.ch3 = &waveChannel{waveRAM: waveRAM}
+		apu.ch4 = &noiseChannel{}
+		apu.nr50 = 0
+		apu.nr51 = 0
+	} else {
+		apu.frameSequencerStep = 0
+	}
+	apu.enabled = on
+}
+
+// lengthCounter silences a channel after a number of 256Hz ticks
+type lengthCounter struct {
+	counter uint16
+	enabled bool
+}
+
+func (l *lengthCounter) clock(channelEnabled *bool) {
+	if l.enabled && l.counter > 0 {
+		l.counter--
+		if l.counter == 0 {
+			*channelEnabled = false
+		}
+	}
+}
+
+// volumeEnvelope fades a channel's volume up or down at 64Hz
+type volumeEnvelope struct {
+	initialVolume uint8
+	increase      bool
+	period        uint8
+
+	volume uint8
+	timer  uint8
+}
+
+func (e *volumeEnvelope) write(val uint8) {
+	e.initialVolume = val >> 4
+	e.increase = isBitSet8(val, 3)
+	e.period = val & 0b111
+}
+
+func (e *volumeEnvelope) trigger() {
+	e.volume = e.initialVolume
+	e.timer = e.period
+}
+
+func (e *volumeEnvelope) clock() {
+	if e.period == 0 {
+		return
+	}
+	if e.timer > 0 {
+		e.timer--
+	}
+	if e.timer == 0 {
+		e.timer = e.period
+		if e.increase && e.volume < 15 {
+			e.volume++
+		} else if !e.increase && e.volume > 0 {
+			e.volume--
+		}
+	}
+}
+
+var dutyPatterns = [4][8]uint8{
+	{0, 0, 0, 0, 0, 0, 0, 1}, // 12.5%
+	{1, 0, 0, 0, 0, 0, 0, 1}, // 25%
+	{1, 0, 0, 0, 0, 1, 1, 1}, // 50%
+	{0, 1, 1, 1, 1, 1, 1, 0}, // 75%
+}
+
+// Channels 1 and 2. Only channel 1 has a frequency sweep.
+type squareChannel struct {
+	// NRx0-NRx4, as last written
+	regs [5]uint8
+
+	hasSweep bool
+	enabled  bool
+
+	length   lengthCounter
+	envelope volumeEnvelope
+
+	timer        int
+	dutyPosition uint8
+
+	sweepEnabled bool
+	sweepTimer   uint8
+	shadowFreq   uint16
+}
+
+func (ch *squareChannel) freq() uint16 {
+	return uint16(ch.regs[4]&0b111)<<8 | uint16(ch.regs[3])
+}
+
+func (ch *squareChannel) setFreq(freq uint16) {
+	ch.regs[3] = uint8(freq)
+	ch.regs[4] = ch.regs[4]&^0b111 | uint8(freq>>8)&0b111
+}
+
+func (ch *squareChannel) period() int {
+	return (2048 - int(ch.freq())) * 4
+}
+
+func (ch *squareChannel) dacEnabled() bool {
+	return ch.regs[2]&0xF8 != 0
+}
+
+func (ch *squareChannel) write(reg uint8, val uint8) {
+	ch.regs[reg] = val
+
+	switch reg {
+	case 1:
+		ch.length.counter = 64 - uint16(val&0x3F)
+	case 2:
+		ch.envelope.write(val)
+		if !ch.dacEnabled() {
+			ch.enabled = false
+		}
+	case 4:
+		ch.length.enabled = isBitSet8(val, 6)
+		if isBitSet8(val, 7) {
+			ch.trigger()
+		}
+	}
+}
+
+func (ch *squareChannel) trigger() {
+	ch.enabled = ch.dacEnabled()
+	if ch.length.counter == 0 {
+		ch.length.counter = 64
+	}
+	ch.timer = ch.period()
+	ch.envelope.trigger()
+
+	if ch.hasSweep {
+		period, shift := ch.sweepParams()
+		ch.shadowFreq = ch.freq()
+		ch.sweepTimer = period
+		if ch.sweepTimer == 0 {
+			ch.sweepTimer = 8
+		}
+		ch.sweepEnabled = period != 0 || shift != 0
+		if shift != 0 {
+			ch.calculateSweep()
+		}
+	}
+}
+
+func (ch *squareChannel) sweepParams() (uint8, uint8) {
+	return (ch.regs[0] >> 4) & 0b111, ch.regs[0] & 0b111
+}
+
+// calculateSweep works out the next frequency, disabling the channel if
+// it would overflow.
+func (ch *squareChannel) calculateSweep() uint16 {
+	_, shift := ch.sweepParams()
+	delta := ch.shadowFreq >> shift
+
+	var newFreq uint16
+	if isBitSet8(ch.regs[0], 3) {
+		newFreq = ch.shadowFreq - delta
+	} else {
+		newFreq = ch.shadowFreq + delta
+	}
+
+	if newFreq > 2047 {
+		ch.enabled = false
+	}
+	return newFreq
+}
+
+func (ch *squareChannel) clockSweep() {
+	if ch.sweepTimer > 0 {
+		ch.sweepTimer--
+	}
+	if ch.sweepTimer != 0 {
+		return
+	}
+
+	period, shift := ch.sweepParams()
+	ch.sweepTimer = period
+	if ch.sweepTimer == 0 {
+		ch.sweepTimer = 8
+	}
+
+	if ch.sweepEnabled && period != 0 {
+		newFreq := ch.calculateSweep()
+		if newFreq <= 2047 && shift != 0 {
+			ch.shadowFreq = newFreq
+			ch.setFreq(newFreq)
+			// Check again for overflow, but don't use the result
+			ch.calculateSweep()
+		}
+	}
+}
+
+func (ch *squareChannel) tick(cycles uint8) {
+	ch.timer -= int(cycles)
+	for ch.timer <= 0 {
+		ch.timer += ch.period()
+		ch.dutyPosition = (ch.dutyPosition + 1) % 8
+	}
+}
+
+func (ch *squareChannel) output() uint8 {
+	if !ch.enabled {
+		return 0
+	}
+	duty := ch.regs[1] >> 6
+	return dutyPatterns[duty][ch.dutyPosition] * ch.envelope.volume
+}
+
+// Channel 3 plays back 32 4-bit samples from wave RAM
+type waveChannel struct {
+	// NR30-NR34, as last written
+	regs    [5]uint8
+	waveRAM [16]uint8
+
+	enabled bool
+	length  lengthCounter
+
+	timer    int
+	position uint8
+}
+
+func (ch *waveChannel) freq() uint16 {
+	return uint16(ch.regs[4]&0b111)<<8 | uint16(ch.regs[3])
+}
+
+func (ch *waveChannel) period() int {
+	return (2048 - int(ch.freq())) * 2
+}
+
+func (ch *waveChannel) dacEnabled() bool {
+	return isBitSet8(ch.regs[0], 7)
+}
+
+func (ch *waveChannel) write(reg uint8, val uint8) {
+	ch.regs[reg] = val
+
+	switch reg {
+	case 0:
+		if !ch.dacEnabled() {
+			ch.enabled = false
+		}
+	case 1:
+		ch.length.counter = 256 - uint16(val)
+	case 4:
+		ch.length.enabled = isBitSet8(val, 6)
+		if isBitSet8(val, 7) {
+			ch.enabled = ch.dacEnabled()
+			if ch.length.counter == 0 {
+				ch.length.counter = 256
+			}
+			ch.timer = ch.period()
+			ch.position = 0
+		}
+	}
+}
+
+func (ch *waveChannel) tick(cycles uint8) {
+	ch.timer -= int(cycles)
+	for ch.timer <= 0 {
+		ch.timer += ch.period()
+		ch.position = (ch.position + 1) % 32
+	}
+}
+
+func (ch *waveChannel) output() uint8 {
+	if !ch.enabled {
+		return 0
+	}
+
+	sample := ch.waveRAM[ch.position/2]
+	if ch.position%2 == 0 {
+		sample >>= 4
+	}
+	sample &= 0x0F
+
+	// NR32 output level: mute, 100%, 50%, 25%
+	switch (ch.regs[2] >> 5) & 0b11 {
+	case 0:
+		return 0
+	case 1:
+		return sample
+	case 2:
+		return sample >> 1
+	default:
+		return sample >> 2
+	}
+}
+
+var noiseDivisors = [8]int{8, 16, 32, 48, 64, 80, 96, 112}
+
+// Channel 4 outputs the low bit of a linear feedback shift register
+type noiseChannel struct {
+	// NR40 (unused) to NR44, as last written
+	regs [5]uint8
+
+	enabled  bool
+	length   lengthCounter
+	envelope volumeEnvelope
+
+	timer int
+	lfsr  uint16
+}
+
+func (ch *noiseChannel) period() int {
+	shift := ch.regs[3] >> 4
+	return noiseDivisors[ch.regs[3]&0b111] << shift
+}
+
+func (ch *noiseChannel) dacEnabled() bool {
+	return ch.regs[2]&0xF8 != 0
+}
+
+func (ch *noiseChannel) write(reg uint8, val uint8) {
+	ch.regs[reg] = val
+
+	switch reg {
+	case 1:
+		ch.length.counter = 64 - uint16(val&0x3F)
+	case 2:
+		ch.envelope.write(val)
+		if !ch.dacEnabled() {
+			ch.enabled = false
+		}
+	case 4:
+		ch.length.enabled = isBitSet8(val, 6)
+		if isBitSet8(val, 7) {
+			ch.enabled = ch.dacEnabled()
+			if ch.length.counter == 0 {
+				ch.length.counter = 64
+			}
+			ch.timer = ch.period()
+			ch.envelope.trigger()
+			ch.lfsr = 0x7FFF
+		}
+	}
+}
+
+func (ch *noiseChannel) tick(cycles uint8) {
+	ch.timer -= int(cycles)
+	for ch.timer <= 0 {
+		ch.timer += ch.period()
+
+		xor := (ch.lfsr & 1) ^ ((ch.lfsr >> 1) & 1)
+		ch.lfsr = (ch.lfsr >> 1) | (xor << 14)
+		// 7-bit mode also feeds back into bit 6
+		if isBitSet8(ch.regs[3], 3) {
+			ch.lfsr = ch.lfsr&^(1<<6) | xor<<6
+		}
+	}
+}
+
+func (ch *noiseChannel) output() uint8 {
+	if !ch.enabled || ch.lfsr&1 != 0 {
+		return 0
+	}
+	return ch.envelope.volume
+}
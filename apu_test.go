@@ -0,0 +1,247 @@
+package gamebert
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAPU() *APU {
+	apu := newAPU(DefaultSampleRate)
+	apu.writeByte(0xFF26, 0x80)
+	apu.writeByte(0xFF24, 0x77)
+	apu.writeByte(0xFF25, 0xFF)
+	return apu
+}
+
+// clockFrameSequencer ticks the APU through n falling edges of DIV bit 4
+func clockFrameSequencer(apu *APU, n int) {
+	for i := 0; i < n; i++ {
+		apu.tick(4, 1<<apuDivBit)
+		apu.tick(4, 0)
+	}
+}
+
+func TestAPUPower(t *testing.T) {
+	apu := newAPU(DefaultSampleRate)
+	assert.Equal(t, uint8(0x70), apu.readByte(0xFF26))
+
+	// Writes are ignored while powered off
+	apu.writeByte(0xFF24, 0x77)
+	assert.Equal(t, uint8(0x00), apu.readByte(0xFF24))
+
+	apu.writeByte(0xFF26, 0x80)
+	apu.writeByte(0xFF24, 0x77)
+	apu.writeByte(0xFF12, 0xF0)
+	apu.writeByte(0xFF30, 0x12)
+	assert.Equal(t, uint8(0xF0), apu.readByte(0xFF26))
+	assert.Equal(t, uint8(0x77), apu.readByte(0xFF24))
+
+	// Powering off clears everything except wave RAM
+	apu.writeByte(0xFF26, 0x00)
+	assert.Equal(t, uint8(0x00), apu.readByte(0xFF24))
+	assert.Equal(t, uint8(0x00), apu.readByte(0xFF12))
+	assert.Equal(t, uint8(0x12), apu.readByte(0xFF30))
+}
+
+func TestAPUReadMasks(t *testing.T) {
+	apu := newTestAPU()
+	apu.writeByte(0xFF11, 0x80)
+	apu.writeByte(0xFF13, 0x12)
+
+	assert.Equal(t, uint8(0xBF), apu.readByte(0xFF11))
+	// Frequency registers are write-only
+	assert.Equal(t, uint8(0xFF), apu.readByte(0xFF13))
+	assert.Equal(t, uint8(0xFF), apu.readByte(0xFF15))
+	assert.Equal(t, uint8(0xFF), apu.readByte(0xFF2A))
+}
+
+func TestAPUTriggerAndLength(t *testing.T) {
+	apu := newTestAPU()
+
+	// DAC off, so triggering doesn't enable the channel
+	apu.writeByte(0xFF14, 0x80)
+	assert.Equal(t, uint8(0xF0), apu.readByte(0xFF26))
+
+	apu.writeByte(0xFF12, 0xF0)
+	apu.writeByte(0xFF11, 0x3E) // length 2
+	apu.writeByte(0xFF14, 0xC0) // trigger with length enabled
+	assert.Equal(t, uint8(0xF1), apu.readByte(0xFF26))
+
+	// Length is clocked on every other frame sequencer step
+	clockFrameSequencer(apu, 2)
+	assert.Equal(t, uint8(0xF1), apu.readByte(0xFF26))
+	clockFrameSequencer(apu, 1)
+	assert.Equal(t, uint8(0xF0), apu.readByte(0xFF26))
+}
+
+func TestAPUEnvelope(t *testing.T) {
+	apu := newTestAPU()
+	apu.writeByte(0xFF21, 0xA1) // volume 10, decreasing every step
+	apu.writeByte(0xFF23, 0x80)
+	assert.Equal(t, uint8(10), apu.ch4.envelope.volume)
+
+	// Envelopes are clocked on step 7
+	clockFrameSequencer(apu, 8)
+	assert.Equal(t, uint8(9), apu.ch4.envelope.volume)
+	clockFrameSequencer(apu, 8)
+	assert.Equal(t, uint8(8), apu.ch4.envelope.volume)
+}
+
+func TestAPUSweepOverflow(t *testing.T) {
+	apu := newTestAPU()
+	apu.writeByte(0xFF12, 0xF0)
+	apu.writeByte(0xFF10, 0x11) // period 1, increasing, shift 1
+	apu.writeByte(0xFF13, 0x00)
+	apu.writeByte(0xFF14, 0x85) // frequency 0x500
+	assert.Equal(t, uint8(0xF1), apu.readByte(0xFF26))
+
+	// The first sweep at step 2 moves to 0x780, and the check that follows
+	// sees the next step would overflow
+	clockFrameSequencer(apu, 3)
+	assert.Equal(t, uint16(0x780), apu.ch1.freq())
+	assert.Equal(t, uint8(0xF0), apu.readByte(0xFF26))
+
+	// Triggering checks for overflow straight away
+	apu.writeByte(0xFF14, 0x87)
+	assert.Equal(t, uint8(0xF0), apu.readByte(0xFF26))
+}
+
+func TestAPUWaveOutput(t *testing.T) {
+	apu := newTestAPU()
+	apu.writeByte(0xFF30, 0xF3)
+	apu.writeByte(0xFF1A, 0x80)
+	apu.writeByte(0xFF1C, 0x20) // 100% volume
+	apu.writeByte(0xFF1E, 0x80)
+	assert.Equal(t, uint8(0x0F), apu.ch3.output())
+
+	apu.ch3.position = 1
+	assert.Equal(t, uint8(0x03), apu.ch3.output())
+
+	apu.writeByte(0xFF1C, 0x40) // 50% volume
+	assert.Equal(t, uint8(0x01), apu.ch3.output())
+}
+
+func TestAPUNoiseLFSR(t *testing.T) {
+	apu := newTestAPU()
+	apu.writeByte(0xFF21, 0xF0)
+	apu.writeByte(0xFF22, 0x00) // shortest period, 15-bit mode
+	apu.writeByte(0xFF23, 0x80)
+	assert.Equal(t, uint16(0x7FFF), apu.ch4.lfsr)
+
+	apu.ch4.tick(8)
+	assert.Equal(t, uint16(0x3FFF), apu.ch4.lfsr)
+
+	// 7-bit mode copies the feedback into bit 6 too
+	apu.writeByte(0xFF22, 0x08)
+	apu.ch4.tick(8)
+	assert.Equal(t, uint16(0x1FBF), apu.ch4.lfsr)
+}
+
+func TestAPUSampleRate(t *testing.T) {
+	apu := newAPU(32768)
+
+	for i := 0; i < cyclesPerSecond/4; i++ {
+		apu.tick(4, 0)
+	}
+	samples := apu.TakeSamples()
+	assert.Equal(t, 32768*2, len(samples))
+	assert.Empty(t, apu.TakeSamples())
+}
+
+func TestAPUPanning(t *testing.T) {
+	apu := newTestAPU()
+	apu.writeByte(0xFF25, 0x10) // channel 1 left only
+	apu.writeByte(0xFF12, 0xF0)
+	apu.writeByte(0xFF11, 0x80)
+	apu.writeByte(0xFF14, 0x80)
+	apu.ch1.dutyPosition = 7
+
+	left, right := apu.mix(apu.mixChannels())
+	assert.NotZero(t, left)
+	assert.Zero(t, right)
+}
+
+func TestAPUSquareFrequency(t *testing.T) {
+	apu := newTestAPU()
+	apu.writeByte(0xFF16, 0x80) // 50% duty
+	apu.writeByte(0xFF17, 0xF0)
+	apu.writeByte(0xFF18, 0x00)
+	apu.writeByte(0xFF19, 0x87) // 131072 / (2048 - 0x700) = 512Hz
+
+	// Count rising edges over one second
+	edges := 0
+	last := apu.ch2.output()
+	for i := 0; i < cyclesPerSecond/4; i++ {
+		apu.ch2.tick(4)
+		out := apu.ch2.output()
+		if out > last {
+			edges++
+		}
+		last = out
+	}
+	assert.Equal(t, 512, edges)
+}
+
+func TestAPUChannelMuting(t *testing.T) {
+	apu := newTestAPU()
+	apu.captureChannels = true
+	apu.writeByte(0xFF17, 0xF0)
+	apu.writeByte(0xFF19, 0x80)
+	apu.writeByte(0xFF21, 0xF0)
+	apu.writeByte(0xFF23, 0x80)
+
+	for i := 0; i < 1000; i++ {
+		apu.tick(4, 0)
+	}
+	samples := apu.TakeSamples()
+	channels := [4][]int16{}
+	for i := range channels {
+		channels[i] = apu.TakeChannelSamples(i)
+		assert.Equal(t, len(samples), len(channels[i]))
+	}
+
+	// With nothing muted, the channels add up to the mix
+	for i := range samples {
+		sum := int16(0)
+		for _, ch := range channels {
+			sum += ch[i]
+		}
+		assert.Equal(t, samples[i], sum)
+	}
+	assert.NotZero(t, channels[1][0])
+	assert.Zero(t, channels[0][0])
+
+	// Muting leaves a channel out of the mix, but it's still captured
+	assert.True(t, apu.ToggleChannel(1))
+	for i := 0; i < 1000; i++ {
+		apu.tick(4, 0)
+	}
+	samples = apu.TakeSamples()
+	ch2 := apu.TakeChannelSamples(1)
+	ch4 := apu.TakeChannelSamples(3)
+	assert.NotZero(t, ch2[0])
+	for i := range samples {
+		assert.Equal(t, ch4[i], samples[i])
+	}
+
+	assert.False(t, apu.ToggleChannel(1))
+}
+
+func TestAPUHighPass(t *testing.T) {
+	apu := newTestAPU()
+	// Channel 3 playing silent wave RAM holds its DAC at a steady level
+	apu.writeByte(0xFF1A, 0x80)
+	apu.writeByte(0xFF1C, 0x20)
+	apu.writeByte(0xFF1E, 0x80)
+
+	for i := 0; i < cyclesPerSecond/4; i++ {
+		apu.tick(4, 0)
+	}
+	samples := apu.TakeSamples()
+
+	// It jumps when the DAC comes on, then the capacitors drain the offset
+	assert.Greater(t, samples[0], int16(0x1000))
+	assert.InDelta(t, 0, samples[len(samples)-2], 16)
+	assert.InDelta(t, 0, samples[len(samples)-1], 16)
+}
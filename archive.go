@@ -0,0 +1,163 @@
+package gamebert
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var romExts = []string{".gb", ".gbc", ".sgb", ".gbs"}
+
+// The most we'll decompress out of an archive: the largest ROM a header can
+// describe, 8MB, with a little to spare for copier headers and the like.
+// Anything bigger isn't a ROM, and may be a decompression bomb.
+const maxArchivedROMSize = 0x8000<<8 + 0x1000
+
+// ReadROMFile reads a ROM, transparently decompressing .zip and .gz files.
+// A particular entry in a zip can be picked with "archive.zip#entry.gb".
+func ReadROMFile(fpath string) ([]byte, error) {
+	fpath, entry := splitArchivePath(fpath)
+
+	data, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return readZipROM(data, entry)
+	case bytes.HasPrefix(data, []byte{0x1F, 0x8B}):
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readArchivedROM(r)
+	default:
+		if entry != "" {
+			return nil, fmt.Errorf("%s is not a zip archive", fpath)
+		}
+		return data, nil
+	}
+}
+
+// splitArchivePath splits "archive.zip#entry.gb" into its parts. Paths
+// that exist as-is are never split.
+func splitArchivePath(fpath string) (string, string) {
+	if _, err := os.Stat(fpath); err != nil {
+		if i := strings.LastIndex(fpath, "#"); i >= 0 {
+			return fpath[:i], fpath[i+1:]
+		}
+	}
+	return fpath, ""
+}
+
+// readArchivedROM decompresses a ROM, refusing to read more than any ROM
+// could be
+func readArchivedROM(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxArchivedROMSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxArchivedROMSize {
+		return nil, fmt.Errorf("Archived file is over %d bytes, too big to be a ROM", maxArchivedROMSize)
+	}
+	return data, nil
+}
+
+// romBasePath strips the extensions from a ROM path, for finding files that
+// live beside the ROM such as saves and patches. A particular entry in a zip
+// gets its own base path, "archive.zip.entry", so that each ROM in a
+// multi-ROM archive has its own saves.
+func romBasePath(fpath string) string {
+	fpath, entry := splitArchivePath(fpath)
+	if entry != "" {
+		entry = strings.TrimSuffix(entry, filepath.Ext(entry))
+		return fpath + "." + sanitizeFileName(entry)
+	}
+
+	fpath = strings.TrimSuffix(fpath, ".gz")
+	return strings.TrimSuffix(fpath, filepath.Ext(fpath))
+}
+
+// sanitizeFileName replaces anything but letters, digits, '-', '_' and '.'
+// with '_', so that a zip entry's name can't reach outside the directory
+// or trip up the filesystem
+func sanitizeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func readZipROM(data []byte, entry string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	var all, candidates []*zip.File
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		all = append(all, f)
+
+		if entry != "" {
+			if f.Name == entry {
+				candidates = append(candidates, f)
+			}
+		} else if isROMName(f.Name) {
+			candidates = append(candidates, f)
+		}
+	}
+
+	switch {
+	case len(candidates) == 1:
+		r, err := candidates[0].Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readArchivedROM(r)
+	case len(candidates) > 1:
+		return nil, fmt.Errorf(
+			"Archive contains more than one ROM, pick one with archive.zip#entry:\n%s",
+			zipEntryNames(candidates))
+	case entry != "":
+		return nil, fmt.Errorf("No entry named %s in archive. Entries:\n%s", entry, zipEntryNames(all))
+	case len(all) == 0:
+		return nil, errors.New("Archive is empty")
+	default:
+		return nil, fmt.Errorf("No ROM found in archive. Entries:\n%s", zipEntryNames(all))
+	}
+}
+
+func isROMName(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, romExt := range romExts {
+		if ext == romExt {
+			return true
+		}
+	}
+	return false
+}
+
+func zipEntryNames(files []*zip.File) string {
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = "  " + f.Name
+	}
+	return strings.Join(names, "\n")
+}
@@ -0,0 +1,99 @@
+package gamebert
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeZip(t *testing.T, fpath string, entries map[string][]byte) {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, data := range entries {
+		w, err := zw.Create(name)
+		assert.NoError(t, err)
+		_, err = w.Write(data)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, ioutil.WriteFile(fpath, buf.Bytes(), 0644))
+}
+
+func TestReadROMFileZip(t *testing.T) {
+	dir := t.TempDir()
+	rom := []byte("rom data")
+
+	single := filepath.Join(dir, "single.zip")
+	writeZip(t, single, map[string][]byte{"game.GB": rom, "readme.txt": []byte("hi")})
+	data, err := ReadROMFile(single)
+	assert.NoError(t, err)
+	assert.Equal(t, rom, data)
+
+	multi := filepath.Join(dir, "multi.zip")
+	writeZip(t, multi, map[string][]byte{"a.gb": rom, "b.gbc": []byte("other")})
+	_, err = ReadROMFile(multi)
+	assert.ErrorContains(t, err, "a.gb")
+	assert.ErrorContains(t, err, "b.gbc")
+
+	data, err = ReadROMFile(multi + "#b.gbc")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("other"), data)
+
+	_, err = ReadROMFile(multi + "#c.gb")
+	assert.Error(t, err)
+
+	none := filepath.Join(dir, "none.zip")
+	writeZip(t, none, map[string][]byte{"readme.txt": []byte("hi")})
+	_, err = ReadROMFile(none)
+	assert.ErrorContains(t, err, "readme.txt")
+}
+
+func TestReadROMFileGzip(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "game.gb.gz")
+	rom := []byte("rom data")
+
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	_, err := zw.Write(rom)
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, ioutil.WriteFile(fpath, buf.Bytes(), 0644))
+
+	data, err := ReadROMFile(fpath)
+	assert.NoError(t, err)
+	assert.Equal(t, rom, data)
+}
+
+func TestReadROMFileTooBig(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "huge.gb.gz")
+
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	_, err := zw.Write(make([]byte, maxArchivedROMSize+1))
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, ioutil.WriteFile(fpath, buf.Bytes(), 0644))
+
+	_, err = ReadROMFile(fpath)
+	assert.ErrorContains(t, err, "too big")
+}
+
+func TestROMBasePath(t *testing.T) {
+	dir := t.TempDir()
+	multi := filepath.Join(dir, "games.zip")
+	writeZip(t, multi, map[string][]byte{"a.gb": nil, "sub/b.gbc": nil})
+
+	assert.Equal(t, filepath.Join(dir, "game"), romBasePath(filepath.Join(dir, "game.gb")))
+	assert.Equal(t, filepath.Join(dir, "game"), romBasePath(filepath.Join(dir, "game.gb.gz")))
+	assert.Equal(t, filepath.Join(dir, "games"), romBasePath(multi))
+
+	// Each entry of a multi-ROM zip gets its own saves
+	assert.Equal(t, filepath.Join(dir, "games.zip.a"), romBasePath(multi+"#a.gb"))
+	assert.Equal(t, filepath.Join(dir, "games.zip.sub_b"), romBasePath(multi+"#sub/b.gbc"))
+	assert.Equal(t, filepath.Join(dir, "games.zip.a.sav"), savePath(multi+"#a.gb"))
+}
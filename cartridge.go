@@ -1,90 +1,391 @@
-package main
+package gamebert
 
 import (
 	"fmt"
-	"io/ioutil"
 )
 
-type Cartridge interface {
+type cartridge interface {
 	read(uint16) uint8
 	write(uint16, uint8)
 }
 
-type MBC0 struct {
-	Rom *ROMSegment
+// Cartridges with hardware that runs alongside the CPU, such as a clock,
+// implement cartridgeTicker.
+type cartridgeTicker interface {
+	tick(cycles uint8)
 }
 
-func NewMBC0(fpath string) *MBC0 {
-	data, err := ioutil.ReadFile(fpath)
+// LoadROM reads the ROM at fpath, out of an archive if need be, and applies
+// the IPS, UPS or BPS patch at patchPath. If patchPath is empty, we look for
+// a patch beside the ROM. Problems with the header that don't stop the ROM
+// running, from ValidateROM, come back as warnings for the caller to show.
+// GBS files are passed through as they are.
+func LoadROM(fpath, patchPath string) (rom []byte, warnings []error, err error) {
+	data, err := ReadROMFile(fpath)
 	if err != nil {
-		panic(err)
+		return nil, nil, err
+	}
+	if IsGBS(data) {
+		return data, nil, nil
 	}
 
-	if len(data) != 32768 {
-		panic(fmt.Sprintf("Not 32kb! %d bytes", len(data)))
+	if patchPath == "" {
+		patchPath = findPatch(fpath)
+	}
+	if patchPath != "" {
+		data, err = applyPatchFile(data, patchPath)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
-	return &MBC0{
-		Rom: NewROMSegment(data),
+	if header, err := ParseCartridgeHeader(data); err == nil {
+		warnings = ValidateROM(data, header)
 	}
+	return data, warnings, nil
 }
 
-func (cart MBC0) read(loc uint16) uint8 {
-	return cart.Rom.read(loc)
+func newCartridgeFromData(data []byte) (cartridge, error) {
+	header, err := ParseCartridgeHeader(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadROM, err)
+	}
+
+	// Pad out truncated dumps so that bank switching never reads past the
+	// end of the ROM.
+	if len(data) < header.ROMSize {
+		padded := make([]byte, header.ROMSize)
+		copy(padded, data)
+		for i := len(data); i < len(padded); i++ {
+			padded[i] = 0xFF
+		}
+		data = padded
+	}
+
+	ct := header.cartType()
+
+	ramSize := 0
+	if ct.ram {
+		ramSize = header.RAMSize
+	}
+
+	var cart cartridge
+	switch ct.mbc {
+	case mbcNone:
+		cart, err = newMBC0(data, ramSize)
+		if err != nil {
+			return nil, err
+		}
+	case mbcKind1:
+		cart = newMBC1(data, ramSize)
+	case mbcKind2:
+		cart = newMBC2(data)
+	case mbcKind3:
+		cart = newMBC3(data, ramSize, ct.rtc)
+	case mbcKind5:
+		cart = newMBC5(data, ramSize, ct.rumble)
+	default:
+		return nil, fmt.Errorf("%w: unsupported cartridge type: %s", ErrBadROM, ct.name)
+	}
+
+	if b, ok := cart.(batteryRAM); ok {
+		b.batteryState().present = ct.battery
+	}
+
+	return cart, nil
 }
 
-func (cart *MBC0) write(loc uint16, val uint8) {
-	// Should never happen, but don't error
+type mbc0 struct {
+	Rom *romSegment
+	ram *ramSegment
+
+	battery
 }
 
-func NewMBC3(fpath string) *MBC3 {
-	data, err := ioutil.ReadFile(fpath)
-	if err != nil {
-		panic(err)
+func newMBC0(data []byte, ramSize int) (*mbc0, error) {
+	if len(data) < 0x8000 {
+		return nil, fmt.Errorf("%w: less than 32KB, %d bytes", ErrBadROM, len(data))
+	}
+
+	var ram *ramSegment
+	if ramSize > 0 {
+		ram = newRAMSegment(uint64(ramSize))
+	}
+
+	return &mbc0{
+		Rom: newROMSegment(data),
+		ram: ram,
+	}, nil
+}
+
+func (cart mbc0) read(loc uint16) uint8 {
+	if loc < 0x8000 {
+		return cart.Rom.read(loc)
+	}
+	if cart.ram == nil {
+		return 0xFF
+	}
+	return cart.ram.read(uint64(loc-0xA000) % uint64(len(cart.ram.data)))
+}
+
+func (cart *mbc0) ramData() []byte {
+	if cart.ram == nil {
+		return nil
+	}
+	return cart.ram.data
+}
+
+func (cart *mbc0) write(loc uint16, val uint8) {
+	// ROM writes should never happen, but don't error
+	if loc >= 0xA000 && loc < 0xC000 && cart.ram != nil {
+		cart.ram.write(uint64(loc-0xA000)%uint64(len(cart.ram.data)), val)
+		cart.ramWritten()
+	}
+}
+
+// https://gbdev.io/pandocs/MBC1.html
+type mbc1 struct {
+	rom      *romSegment
+	romBanks uint32
+
+	ram        *ramSegment
+	ramEnabled bool
+
+	// 5-bit register at 0x2000-0x3FFF
+	bank1 uint8
+	// 2-bit register at 0x4000-0x5FFF. Upper ROM bank bits, or the RAM bank
+	// in mode 1.
+	bank2 uint8
+	// Banking mode select at 0x6000-0x7FFF
+	mode uint8
+
+	battery
+}
+
+func newMBC1(data []byte, ramSize int) *mbc1 {
+	var ram *ramSegment
+	if ramSize > 0 {
+		ram = newRAMSegment(uint64(ramSize))
+	}
+
+	return &mbc1{
+		rom:      newROMSegment(data),
+		romBanks: uint32(len(data) / 0x4000),
+		ram:      ram,
+		bank1:    1,
+	}
+}
+
+func (m *mbc1) read(loc uint16) uint8 {
+	switch {
+	case loc < 0x4000:
+		// In mode 1 the "bank 0" region is switchable too, using only the
+		// upper bank bits. This is how the 0x20/0x40/0x60 banks are reached
+		// on large ROMs.
+		bank := uint32(0)
+		if m.mode == 1 {
+			bank = uint32(m.bank2) << 5
+		}
+		bank %= m.romBanks
+		return m.rom.read(uint64(bank)*0x4000 + uint64(loc))
+	case loc < 0x8000:
+		bank := (uint32(m.bank2)<<5 | uint32(m.bank1)) % m.romBanks
+		return m.rom.read(uint64(bank)*0x4000 + uint64(loc) - 0x4000)
+	case loc >= 0xA000 && loc < 0xC000:
+		if !m.ramEnabled || m.ram == nil {
+			return 0xFF
+		}
+		return m.ram.read(m.ramOffset(loc))
+	default:
+		return 0xFF
+	}
+}
+
+func (m *mbc1) ramData() []byte {
+	if m.ram == nil {
+		return nil
+	}
+	return m.ram.data
+}
+
+func (m *mbc1) write(loc uint16, val uint8) {
+	switch {
+	case loc < 0x2000:
+		m.ramEnabled = val&0x0F == 0x0A
+		if !m.ramEnabled {
+			m.ramDisabled()
+		}
+	case loc < 0x4000:
+		// The zero check happens on the full 5 bits, so e.g. 0x20 selects
+		// bank 0x21 rather than 0x20.
+		m.bank1 = val & 0x1F
+		if m.bank1 == 0 {
+			m.bank1 = 1
+		}
+	case loc < 0x6000:
+		m.bank2 = val & 0x03
+	case loc < 0x8000:
+		m.mode = val & 0x01
+	case loc >= 0xA000 && loc < 0xC000:
+		if m.ramEnabled && m.ram != nil {
+			m.ram.write(m.ramOffset(loc), val)
+			m.ramWritten()
+		}
+	}
+}
+
+func (m *mbc1) ramOffset(loc uint16) uint64 {
+	bank := uint64(0)
+	if m.mode == 1 {
+		bank = uint64(m.bank2)
 	}
-	return &MBC3{
-		rom:             NewROMSegment(data),
+	offset := bank*0x2000 + uint64(loc) - 0xA000
+	return offset % uint64(len(m.ram.data))
+}
+
+// https://gbdev.io/pandocs/MBC2.html
+type mbc2 struct {
+	rom             *romSegment
+	romBanks        uint32
+	selectedRomBank uint32
+
+	// 512 half-bytes. Only the low nibble of each byte is used.
+	ram        *ramSegment
+	ramEnabled bool
+
+	battery
+}
+
+const mbc2RAMSize = 0x200
+
+func newMBC2(data []byte) *mbc2 {
+	return &mbc2{
+		rom:             newROMSegment(data),
+		romBanks:        uint32(len(data) / 0x4000),
 		selectedRomBank: 1,
-		ram:             NewRAMSegment(0x8000),
-		rtc:             make([]byte, 0x10),
-		latchedRtc:      make([]byte, 0x10),
+		ram:             newRAMSegment(mbc2RAMSize),
 	}
 }
 
-type MBC3 struct {
-	rom             *ROMSegment
+func (m *mbc2) read(loc uint16) uint8 {
+	switch {
+	case loc < 0x4000:
+		return m.rom.read(uint64(loc))
+	case loc < 0x8000:
+		bank := m.selectedRomBank % m.romBanks
+		return m.rom.read(uint64(bank)*0x4000 + uint64(loc) - 0x4000)
+	case loc >= 0xA000 && loc < 0xC000:
+		if !m.ramEnabled {
+			return 0xFF
+		}
+		// Only 9 address bits are wired up, so the RAM echoes across the
+		// whole region. The upper nibble isn't connected and reads as 1s.
+		return m.ram.read((loc-0xA000)%mbc2RAMSize) | 0xF0
+	default:
+		return 0xFF
+	}
+}
+
+func (m *mbc2) ramData() []byte {
+	if m.ram == nil {
+		return nil
+	}
+	return m.ram.data
+}
+
+func (m *mbc2) write(loc uint16, val uint8) {
+	switch {
+	case loc < 0x4000:
+		// Bit 8 of the address picks which register is written to
+		if isBitSet16(loc, 8) {
+			m.selectedRomBank = uint32(val & 0x0F)
+			if m.selectedRomBank == 0 {
+				m.selectedRomBank = 1
+			}
+		} else {
+			m.ramEnabled = val&0x0F == 0x0A
+			if !m.ramEnabled {
+				m.ramDisabled()
+			}
+		}
+	case loc >= 0xA000 && loc < 0xC000:
+		if m.ramEnabled {
+			m.ram.write((loc-0xA000)%mbc2RAMSize, val&0x0F)
+			m.ramWritten()
+		}
+	}
+}
+
+func newMBC3(data []byte, ramSize int, hasRTC bool) *mbc3 {
+	var ram *ramSegment
+	if ramSize > 0 {
+		ram = newRAMSegment(uint64(ramSize))
+	}
+
+	var rtc *rtc
+	if hasRTC {
+		rtc = newRTC()
+	}
+
+	return &mbc3{
+		rom:             newROMSegment(data),
+		romBanks:        uint32(len(data) / 0x4000),
+		selectedRomBank: 1,
+		ram:             ram,
+		rtc:             rtc,
+	}
+}
+
+type mbc3 struct {
+	rom             *romSegment
+	romBanks        uint32
 	selectedRomBank uint32
 
-	ram             *RAMSegment
+	ram             *ramSegment
 	selectedRamBank uint32
 	ramEnabled      bool
 
-	rtc        []byte
-	latchedRtc []byte
-	latched    bool
+	// nil if the cartridge has no clock
+	rtc *rtc
+
+	battery
 }
 
-func (r *MBC3) read(loc uint16) byte {
+func (r *mbc3) read(loc uint16) byte {
 	switch {
 	case loc < 0x4000:
 		return r.rom.read(uint64(loc))
 	case loc < 0x8000:
-		return r.rom.read(uint64(loc) - 0x4000 + uint64(r.selectedRomBank)*0x4000)
+		bank := r.selectedRomBank % r.romBanks
+		return r.rom.read(uint64(loc) - 0x4000 + uint64(bank)*0x4000)
 	default:
-		if r.selectedRamBank >= 0x4 {
-			if r.latched {
-				return r.latchedRtc[r.selectedRamBank]
+		if r.selectedRamBank >= 0x08 {
+			if r.rtc == nil || r.selectedRamBank > 0x0C {
+				return 0xFF
 			}
-			return r.rtc[r.selectedRamBank]
+			return r.rtc.read(uint8(r.selectedRamBank - 0x08))
+		}
+		if r.ram == nil {
+			return 0xFF
 		}
-		return r.ram.read((0x2000*uint64(r.selectedRamBank) + uint64(loc) - 0xA000))
+		return r.ram.read(r.ramOffset(loc))
+	}
+}
+
+func (r *mbc3) ramData() []byte {
+	if r.ram == nil {
+		return nil
 	}
+	return r.ram.data
 }
 
-func (r *MBC3) write(loc uint16, value uint8) {
+func (r *mbc3) write(loc uint16, value uint8) {
 	switch {
 	case loc < 0x2000:
 		r.ramEnabled = (value & 0xA) != 0
+		if !r.ramEnabled {
+			r.ramDisabled()
+		}
 	case loc < 0x4000:
 		r.selectedRomBank = uint32(value & 0x7F)
 		if r.selectedRomBank == 0x00 {
@@ -93,21 +394,161 @@ func (r *MBC3) write(loc uint16, value uint8) {
 	case loc < 0x6000:
 		r.selectedRamBank = uint32(value)
 	case loc < 0x8000:
-		if value == 0x1 {
-			r.latched = false
-		} else if value == 0x0 {
-			r.latched = true
-			copy(r.rtc, r.latchedRtc)
+		if r.rtc != nil {
+			r.rtc.writeLatch(value)
 		}
 	case loc < 0xA000:
-		panic("This should never happen - mapped to VRAM")
+		// Mapped to VRAM, so this never reaches the cartridge
 	case loc < 0xC000:
 		if r.ramEnabled {
-			if r.selectedRamBank >= 0x4 {
-				r.rtc[r.selectedRamBank] = value
-			} else {
-				r.ram.write(uint64(r.selectedRamBank)*0x2000+uint64(loc)-0xA000, value)
+			if r.selectedRamBank >= 0x08 {
+				if r.rtc != nil && r.selectedRamBank <= 0x0C {
+					r.rtc.write(uint8(r.selectedRamBank-0x08), value)
+					r.ramWritten()
+				}
+			} else if r.ram != nil {
+				r.ram.write(r.ramOffset(loc), value)
+				r.ramWritten()
 			}
 		}
 	}
 }
+
+// setRTCClock chooses whether the clock follows emulated cycles or the
+// host's wall clock.
+func (r *mbc3) setRTCClock(clock RTCClock) {
+	if r.rtc != nil {
+		r.rtc.setClock(clock)
+	}
+}
+
+func (r *mbc3) tick(cycles uint8) {
+	if r.rtc != nil {
+		r.rtc.tick(cycles)
+	}
+}
+
+func (r *mbc3) saveFooter() []byte {
+	if r.rtc == nil {
+		return nil
+	}
+	return r.rtc.footer()
+}
+
+func (r *mbc3) loadSaveFooter(buf []byte) error {
+	if r.rtc == nil {
+		return nil
+	}
+	return r.rtc.loadFooter(buf)
+}
+
+// ramOffset maps an address in 0xA000-0xBFFF to an offset into RAM,
+// wrapping carts that have less RAM than the selected bank implies.
+func (r *mbc3) ramOffset(loc uint16) uint64 {
+	offset := 0x2000*uint64(r.selectedRamBank&0x03) + uint64(loc) - 0xA000
+	return offset % uint64(len(r.ram.data))
+}
+
+// https://gbdev.io/pandocs/MBC5.html
+type mbc5 struct {
+	rom             *romSegment
+	romBanks        uint32
+	selectedRomBank uint32 // 9 bits, and unlike the other MBCs 0 is allowed
+
+	ram             *ramSegment
+	selectedRamBank uint32
+	ramEnabled      bool
+
+	// Rumble carts steal bit 3 of the RAM bank register for the motor
+	hasRumble      bool
+	rumbling       bool
+	rumbleCallback func(bool)
+
+	battery
+}
+
+func newMBC5(data []byte, ramSize int, hasRumble bool) *mbc5 {
+	var ram *ramSegment
+	if ramSize > 0 {
+		ram = newRAMSegment(uint64(ramSize))
+	}
+
+	return &mbc5{
+		rom:             newROMSegment(data),
+		romBanks:        uint32(len(data) / 0x4000),
+		selectedRomBank: 1,
+		ram:             ram,
+		hasRumble:       hasRumble,
+	}
+}
+
+// setRumbleCallback registers a function that is called whenever the
+// rumble motor is switched on or off.
+func (m *mbc5) setRumbleCallback(cb func(on bool)) {
+	m.rumbleCallback = cb
+}
+
+func (m *mbc5) read(loc uint16) uint8 {
+	switch {
+	case loc < 0x4000:
+		return m.rom.read(uint64(loc))
+	case loc < 0x8000:
+		bank := m.selectedRomBank % m.romBanks
+		return m.rom.read(uint64(bank)*0x4000 + uint64(loc) - 0x4000)
+	case loc >= 0xA000 && loc < 0xC000:
+		if !m.ramEnabled || m.ram == nil {
+			return 0xFF
+		}
+		return m.ram.read(m.ramOffset(loc))
+	default:
+		return 0xFF
+	}
+}
+
+func (m *mbc5) ramData() []byte {
+	if m.ram == nil {
+		return nil
+	}
+	return m.ram.data
+}
+
+func (m *mbc5) write(loc uint16, val uint8) {
+	switch {
+	case loc < 0x2000:
+		m.ramEnabled = val&0x0F == 0x0A
+		if !m.ramEnabled {
+			m.ramDisabled()
+		}
+	case loc < 0x3000:
+		m.selectedRomBank = (m.selectedRomBank & 0x100) | uint32(val)
+	case loc < 0x4000:
+		m.selectedRomBank = (m.selectedRomBank & 0xFF) | uint32(val&0x01)<<8
+	case loc < 0x6000:
+		if m.hasRumble {
+			m.setRumble(isBitSet8(val, 3))
+			m.selectedRamBank = uint32(val & 0x07)
+		} else {
+			m.selectedRamBank = uint32(val & 0x0F)
+		}
+	case loc >= 0xA000 && loc < 0xC000:
+		if m.ramEnabled && m.ram != nil {
+			m.ram.write(m.ramOffset(loc), val)
+			m.ramWritten()
+		}
+	}
+}
+
+func (m *mbc5) setRumble(on bool) {
+	if on == m.rumbling {
+		return
+	}
+	m.rumbling = on
+	if m.rumbleCallback != nil {
+		m.rumbleCallback(on)
+	}
+}
+
+func (m *mbc5) ramOffset(loc uint16) uint64 {
+	offset := uint64(m.selectedRamBank)*0x2000 + uint64(loc) - 0xA000
+	return offset % uint64(len(m.ram.data))
+}
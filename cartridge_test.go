@@ -0,0 +1,208 @@
+package gamebert
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// makeROM builds a blank ROM of the given size with a header describing it.
+func makeROM(size int, cartType, romSizeCode, ramSizeCode uint8, title string) []byte {
+	data := make([]byte, size)
+	copy(data[0x0134:], title)
+	data[0x0147] = cartType
+	data[0x0148] = romSizeCode
+	data[0x0149] = ramSizeCode
+
+	// Tag each bank with its own number so that bank switching is visible
+	for bank := 0; bank < size/0x4000; bank++ {
+		data[bank*0x4000+0x1000] = uint8(bank)
+	}
+	return data
+}
+
+func TestParseCartridgeHeader(t *testing.T) {
+	data := makeROM(0x10000, 0x13, 0x01, 0x03, "POKEMON BLUE")
+	data[0x014B] = 0x01
+	data[0x0146] = 0x03
+
+	h, err := ParseCartridgeHeader(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "POKEMON BLUE", h.Title)
+	assert.Equal(t, "MBC3+RAM+BATTERY", h.TypeName())
+	assert.Equal(t, 0x10000, h.ROMSize)
+	assert.Equal(t, 0x8000, h.RAMSize)
+	assert.True(t, h.HasBattery())
+	assert.False(t, h.HasRTC())
+	assert.True(t, h.IsSGB())
+	assert.False(t, h.IsCGB())
+	assert.Equal(t, "01", h.Licensee())
+
+	_, err = ParseCartridgeHeader(data[:0x100])
+	assert.Error(t, err)
+}
+
+func TestNewCartridgeFromData(t *testing.T) {
+	cart, err := newCartridgeFromData(makeROM(0x8000, 0x00, 0x00, 0x00, "TETRIS"))
+	assert.NoError(t, err)
+	assert.IsType(t, &mbc0{}, cart)
+
+	cart, err = newCartridgeFromData(makeROM(0x10000, 0x11, 0x01, 0x00, "MBC3"))
+	assert.NoError(t, err)
+	assert.IsType(t, &mbc3{}, cart)
+
+	_, err = newCartridgeFromData(makeROM(0x8000, 0xFD, 0x00, 0x00, "TAMA5"))
+	assert.ErrorIs(t, err, ErrBadROM)
+
+	_, err = newMBC0(make([]byte, 0x4000), 0)
+	assert.ErrorIs(t, err, ErrBadROM)
+}
+
+func TestMBC3ROMBanking(t *testing.T) {
+	cart := newMBC3(makeROM(0x20000, 0x11, 0x02, 0x00, "MBC3"), 0, false)
+
+	assert.Equal(t, uint8(1), cart.read(0x5000))
+	cart.write(0x2000, 0x05)
+	assert.Equal(t, uint8(5), cart.read(0x5000))
+	// Bank 0 maps to bank 1
+	cart.write(0x2000, 0x00)
+	assert.Equal(t, uint8(1), cart.read(0x5000))
+	// Banks beyond the end of the ROM wrap around
+	cart.write(0x2000, 0x09)
+	assert.Equal(t, uint8(1), cart.read(0x5000))
+}
+
+func TestMBC1ROMBanking(t *testing.T) {
+	// 1MB ROM - 64 banks, so bank2 is needed to reach the upper half
+	cart := newMBC1(makeROM(0x100000, 0x01, 0x05, 0x00, "MBC1"), 0)
+
+	assert.Equal(t, uint8(1), cart.read(0x5000))
+	cart.write(0x2000, 0x00)
+	assert.Equal(t, uint8(1), cart.read(0x5000))
+	cart.write(0x2000, 0x1F)
+	assert.Equal(t, uint8(0x1F), cart.read(0x5000))
+
+	cart.write(0x4000, 0x01)
+	assert.Equal(t, uint8(0x3F), cart.read(0x5000))
+	// Writing 0x20 selects bank 0x21, since only the low 5 bits are checked
+	cart.write(0x2000, 0x20)
+	assert.Equal(t, uint8(0x21), cart.read(0x5000))
+
+	// Mode 0: 0x0000-0x3FFF is always bank 0
+	assert.Equal(t, uint8(0x00), cart.read(0x1000))
+	// Mode 1: 0x0000-0x3FFF follows bank2
+	cart.write(0x6000, 0x01)
+	assert.Equal(t, uint8(0x20), cart.read(0x1000))
+}
+
+func TestMBC1RAMBanking(t *testing.T) {
+	cart := newMBC1(makeROM(0x10000, 0x03, 0x01, 0x03, "MBC1"), 0x8000)
+
+	// Disabled RAM reads as 0xFF and ignores writes
+	cart.write(0xA000, 0x42)
+	assert.Equal(t, uint8(0xFF), cart.read(0xA000))
+
+	cart.write(0x0000, 0x0A)
+	cart.write(0xA000, 0x42)
+	assert.Equal(t, uint8(0x42), cart.read(0xA000))
+
+	// In mode 0 bank2 doesn't affect RAM
+	cart.write(0x4000, 0x02)
+	assert.Equal(t, uint8(0x42), cart.read(0xA000))
+
+	cart.write(0x6000, 0x01)
+	assert.Equal(t, uint8(0x00), cart.read(0xA000))
+	cart.write(0xA000, 0x24)
+	cart.write(0x4000, 0x00)
+	assert.Equal(t, uint8(0x42), cart.read(0xA000))
+}
+
+func TestMBC5Banking(t *testing.T) {
+	// 8MB ROM - 512 banks
+	cart := newMBC5(makeROM(0x800000, 0x1B, 0x08, 0x04, "MBC5"), 0x20000, false)
+
+	cart.write(0x2000, 0x00)
+	assert.Equal(t, uint8(0x00), cart.read(0x5000))
+	cart.write(0x2000, 0xFF)
+	assert.Equal(t, uint8(0xFF), cart.read(0x5000))
+	cart.write(0x3000, 0x01)
+	assert.Equal(t, uint8(0xFF), cart.read(0x5000))
+	assert.Equal(t, uint32(0x1FF), cart.selectedRomBank)
+
+	cart.write(0x0000, 0x0A)
+	cart.write(0x4000, 0x0F)
+	cart.write(0xA000, 0x42)
+	cart.write(0x4000, 0x00)
+	assert.Equal(t, uint8(0x00), cart.read(0xA000))
+	cart.write(0x4000, 0x0F)
+	assert.Equal(t, uint8(0x42), cart.read(0xA000))
+}
+
+func TestMBC5Rumble(t *testing.T) {
+	cart := newMBC5(makeROM(0x10000, 0x1E, 0x01, 0x02, "MBC5"), 0x2000, true)
+
+	var calls []bool
+	cart.setRumbleCallback(func(on bool) {
+		calls = append(calls, on)
+	})
+
+	cart.write(0x4000, 0x08)
+	cart.write(0x4000, 0x08)
+	cart.write(0x4000, 0x00)
+	assert.Equal(t, []bool{true, false}, calls)
+}
+
+func TestMBC2(t *testing.T) {
+	cart := newMBC2(makeROM(0x40000, 0x06, 0x03, 0x00, "MBC2"))
+
+	// Bit 8 set - ROM bank select
+	cart.write(0x2100, 0x05)
+	assert.Equal(t, uint8(5), cart.read(0x5000))
+	cart.write(0x2100, 0x00)
+	assert.Equal(t, uint8(1), cart.read(0x5000))
+
+	// Bit 8 clear - RAM enable
+	assert.Equal(t, uint8(0xFF), cart.read(0xA000))
+	cart.write(0x0000, 0x0A)
+	cart.write(0xA000, 0x3C)
+	assert.Equal(t, uint8(0xFC), cart.read(0xA000))
+	// RAM echoes every 512 bytes
+	assert.Equal(t, uint8(0xFC), cart.read(0xA200))
+	assert.Equal(t, uint8(0xFC), cart.read(0xBE00))
+}
+
+func TestValidateROM(t *testing.T) {
+	data := makeROM(0x8000, 0x00, 0x00, 0x00, "TETRIS")
+	copy(data[0x0104:], nintendoLogo)
+	data[0x014D] = ComputeHeaderChecksum(data)
+	global := ComputeGlobalChecksum(data)
+	data[0x014E], data[0x014F] = chunk16(global)
+
+	h, err := ParseCartridgeHeader(data)
+	assert.NoError(t, err)
+	assert.Empty(t, ValidateROM(data, h))
+
+	// Corrupting a byte outside the header only breaks the global checksum
+	data[0x2000] ^= 0xFF
+	errs := ValidateROM(data, h)
+	assert.Len(t, errs, 1)
+	assert.ErrorContains(t, errs[0], "Global checksum")
+
+	data[0x0104] = 0x00
+	data[0x0134] = 'X'
+	assert.Len(t, ValidateROM(data, h), 3)
+}
+
+func TestLoadROMWarnings(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "game.gb")
+	rom := makeROM(0x8000, 0x00, 0x00, 0x00, "TETRIS")
+	assert.NoError(t, ioutil.WriteFile(fpath, rom, 0644))
+
+	// A blank logo and wrong checksums are worth a warning, but still load
+	data, warnings, err := LoadROM(fpath, "")
+	assert.NoError(t, err)
+	assert.Equal(t, rom, data)
+	assert.Len(t, warnings, 3)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// AudioSink receives the APU's output as interleaved stereo samples
+// (left, right, left, ...).
+type AudioSink interface {
+	WriteSamples(samples []int16) error
+	Close() error
+}
+
+// Sinks that play in real time report how full their buffer is, so that we
+// can nudge the APU's output rate to stop it running dry or overflowing.
+type audioBufferer interface {
+	// bufferFill is 0 when empty and 1 when full
+	bufferFill() float64
+}
+
+// The most we stretch or squash the output rate by. Half a percent is too
+// small to hear as a change in pitch.
+// https://docs.libretro.com/development/cores/dynamic-rate-control/
+const maxRateDelta = 0.005
+
+// rateControl returns a factor to scale the APU's cycles per sample by,
+// aiming to keep the buffer half full.
+func rateControl(fill float64) float64 {
+	return 1 + maxRateDelta*(2*fill-1)
+}
+
+// audioQueue is a fixed-size FIFO of little-endian samples, filled by the
+// emulator and drained by the audio backend from its own goroutine.
+type audioQueue struct {
+	mu       sync.Mutex
+	buf      []byte
+	capacity int
+}
+
+func newAudioQueue(capacitySamples int) *audioQueue {
+	return &audioQueue{capacity: capacitySamples * 2}
+}
+
+// write queues samples, dropping any that don't fit
+func (q *audioQueue) write(samples []int16) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, s := range samples {
+		if len(q.buf)+2 > q.capacity {
+			return
+		}
+		q.buf = binary.LittleEndian.AppendUint16(q.buf, uint16(s))
+	}
+}
+
+// Read fills p from the queue, padding with silence if the queue runs dry
+// so that the backend never stalls.
+func (q *audioQueue) Read(p []byte) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := copy(p, q.buf)
+	q.buf = q.buf[:copy(q.buf, q.buf[n:])]
+	for i := n; i < len(p); i++ {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// fits reports whether n more samples would fit in the queue
+func (q *audioQueue) fits(n int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.buf)+n*2 <= q.capacity
+}
+
+func (q *audioQueue) bufferFill() float64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return float64(len(q.buf)) / float64(q.capacity)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/hajimehoshi/oto/v2"
+	"github.com/robert/gamebert"
+)
+
+// How much audio we queue ahead of the sound card, in frames. Rate control
+// aims to keep it half full.
+const hostAudioFrames = 4
+
+// HostAudio plays samples through the host's sound card
+type HostAudio struct {
+	*audioQueue
+	player oto.Player
+}
+
+func NewHostAudio(sampleRate int) (*HostAudio, error) {
+	ctx, ready, err := oto.NewContext(sampleRate, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+	<-ready
+
+	samplesPerFrame := sampleRate / gamebert.FramesPerSecond * 2
+
+	h := &HostAudio{
+		audioQueue: newAudioQueue(hostAudioFrames * samplesPerFrame),
+	}
+	h.player = ctx.NewPlayer(h.audioQueue)
+	// Keep oto's own buffer small so that our queue reflects the latency
+	if bs, ok := h.player.(oto.BufferSizeSetter); ok {
+		bs.SetBufferSize(samplesPerFrame * 2)
+	}
+	h.player.Play()
+
+	return h, nil
+}
+
+// WriteSamples drops the whole batch if it doesn't fit, which happens when
+// we're running faster than the speakers can play. That's less noticeable
+// than cutting it off partway.
+func (h *HostAudio) WriteSamples(samples []int16) error {
+	if h.fits(len(samples)) {
+		h.write(samples)
+	}
+	return h.player.Err()
+}
+
+func (h *HostAudio) Close() error {
+	return h.player.Close()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAudioQueue(t *testing.T) {
+	q := newAudioQueue(4)
+	q.write([]int16{1, -1, 0x1234})
+	assert.Equal(t, 0.75, q.bufferFill())
+	assert.True(t, q.fits(1))
+	assert.False(t, q.fits(2))
+
+	// Samples that don't fit are dropped
+	q.write([]int16{5, 6})
+	assert.Equal(t, 1.0, q.bufferFill())
+
+	p := make([]byte, 6)
+	n, err := q.Read(p)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, []byte{0x01, 0x00, 0xFF, 0xFF, 0x34, 0x12}, p)
+
+	// Running dry pads with silence
+	p = []byte{9, 9, 9, 9}
+	n, _ = q.Read(p)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, []byte{0x05, 0x00, 0x00, 0x00}, p)
+	assert.Equal(t, 0.0, q.bufferFill())
+}
+
+func TestRateControl(t *testing.T) {
+	assert.Equal(t, 1.0, rateControl(0.5))
+	// An emptying buffer needs more samples, so fewer cycles per sample
+	assert.Less(t, rateControl(0.1), 1.0)
+	assert.Greater(t, rateControl(0.9), 1.0)
+	assert.InDelta(t, 1+maxRateDelta, rateControl(1), 1e-9)
+}
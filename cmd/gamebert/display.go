@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/robert/gamebert"
+)
+
+// Palette maps the 4 DMG shades, from lightest to darkest, to colors.
+type Palette [4]color.RGBA
+
+func grey(v uint8) color.RGBA {
+	return color.RGBA{v, v, v, 0xFF}
+}
+
+var palettes = map[string]Palette{
+	"grey":   {grey(206), grey(156), grey(106), grey(56)},
+	"bw":     {grey(0xFF), grey(0xAA), grey(0x55), grey(0x00)},
+	"green":  {{0x9B, 0xBC, 0x0F, 0xFF}, {0x8B, 0xAC, 0x0F, 0xFF}, {0x30, 0x62, 0x30, 0xFF}, {0x0F, 0x38, 0x0F, 0xFF}},
+	"pocket": {{0xC4, 0xCF, 0xA1, 0xFF}, {0x8B, 0x95, 0x6D, 0xFF}, {0x4D, 0x53, 0x3C, 0xFF}, {0x1F, 0x1F, 0x1F, 0xFF}},
+}
+
+// ParsePalette accepts either the name of a built-in palette or 4
+// comma-separated hex colors, lightest first, e.g.
+// "e0f8d0,88c070,346856,081820".
+func ParsePalette(s string) (Palette, error) {
+	if p, ok := palettes[s]; ok {
+		return p, nil
+	}
+
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		names := make([]string, 0, len(palettes))
+		for name := range palettes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return Palette{}, fmt.Errorf(
+			"Unknown palette %q: use one of %s, or 4 comma-separated hex colors",
+			s, strings.Join(names, ", "))
+	}
+
+	var p Palette
+	for i, part := range parts {
+		v, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(part), "#"), 16, 32)
+		if err != nil || v > 0xFFFFFF {
+			return Palette{}, fmt.Errorf("Invalid color in palette: %q", part)
+		}
+		p[i] = color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xFF}
+	}
+	return p, nil
+}
+
+type Display struct {
+	win     *pixelgl.Window
+	scale   float64
+	palette Palette
+}
+
+// frameImage colors in a Machine's framebuffer
+func frameImage(fb []uint8, palette Palette) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, gamebert.ScreenWidth, gamebert.ScreenHeight))
+
+	for x := 0; x < gamebert.ScreenWidth; x++ {
+		for y := 0; y < gamebert.ScreenHeight; y++ {
+			m.Set(x, y, palette[fb[y*gamebert.ScreenWidth+x]&0b11])
+		}
+	}
+
+	return m
+}
+
+func (d *Display) draw(fb []uint8) {
+	d.win.Clear(color.Black)
+
+	p := pixel.PictureDataFromImage(frameImage(fb, d.palette))
+
+	c := d.win.Bounds().Center()
+	pixel.NewSprite(p, p.Bounds()).
+		Draw(d.win, pixel.IM.Moved(c).Scaled(c, d.scale))
+
+	d.win.Update()
+}
+
+func writeScreenshot(fpath string, fb []uint8, palette Palette) error {
+	f, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(f, frameImage(fb, palette)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/robert/gamebert"
+)
+
+// The joypad, on the keyboard
+var joypadKeys = [...]pixelgl.Button{
+	gamebert.ButtonRight:  pixelgl.KeyRight,
+	gamebert.ButtonLeft:   pixelgl.KeyLeft,
+	gamebert.ButtonUp:     pixelgl.KeyUp,
+	gamebert.ButtonDown:   pixelgl.KeyDown,
+	gamebert.ButtonA:      pixelgl.KeyA,
+	gamebert.ButtonB:      pixelgl.KeyS,
+	gamebert.ButtonSelect: pixelgl.KeyD,
+	gamebert.ButtonStart:  pixelgl.KeyF,
+}
+
+// windowInput reads the joypad from the window's keyboard
+type windowInput struct {
+	win *pixelgl.Window
+}
+
+func (in windowInput) Pressed(b gamebert.Button) bool {
+	return in.win.Pressed(joypadKeys[b])
+}
+
+// Frontend hotkeys, alongside the joypad keys:
+//
+//	1-4             mute or unmute sound channels 1-4
+//	F1-F9           load save state slot 1-9
+//	Shift+F1-F9     save to save state slot 1-9
+//	Backspace       rewind, for as long as it's held
+//	Tab             fast-forward, for as long as it's held
+//	Shift+Tab       toggle fast-forward
+//	-/=             step the speed down/up
+//	0               back to normal speed
+//	P               pause or unpause
+//	N               pause, and run a single frame
+//
+// and when playing a GBS file:
+//
+//	Left/Right  previous/next track
+var channelKeys = [4]pixelgl.Button{pixelgl.Key1, pixelgl.Key2, pixelgl.Key3, pixelgl.Key4}
+
+const (
+	rewindKey       = pixelgl.KeyBackspace
+	fastForwardKey  = pixelgl.KeyTab
+	speedDownKey    = pixelgl.KeyMinus
+	speedUpKey      = pixelgl.KeyEqual
+	speedResetKey   = pixelgl.Key0
+	pauseKey        = pixelgl.KeyP
+	frameAdvanceKey = pixelgl.KeyN
+)
+
+var stateKeys = [gamebert.SaveStateSlots]pixelgl.Button{
+	pixelgl.KeyF1, pixelgl.KeyF2, pixelgl.KeyF3, pixelgl.KeyF4, pixelgl.KeyF5,
+	pixelgl.KeyF6, pixelgl.KeyF7, pixelgl.KeyF8, pixelgl.KeyF9,
+}
+
+func shiftPressed(win *pixelgl.Window) bool {
+	return win.Pressed(pixelgl.KeyLeftShift) || win.Pressed(pixelgl.KeyRightShift)
+}
+
+// handleHotkeys is called after each time the window has been updated
+func handleHotkeys(win *pixelgl.Window, m *gamebert.Machine, romPath string, speed *speedControl) {
+	titleChanged := handleChannelHotkeys(win, m.APU())
+	if handleSpeedHotkeys(win, speed) {
+		titleChanged = true
+	}
+	if titleChanged {
+		win.SetTitle(windowTitle("Gamebert", m.APU(), speed))
+	}
+	handleStateHotkeys(win, m, romPath)
+}
+
+// handleSpeedHotkeys returns whether anything changed
+func handleSpeedHotkeys(win *pixelgl.Window, speed *speedControl) bool {
+	before := *speed
+
+	if win.JustPressed(fastForwardKey) && shiftPressed(win) {
+		speed.fastForwardToggled = !speed.fastForwardToggled
+	}
+	speed.fastForwardHeld = win.Pressed(fastForwardKey) && !shiftPressed(win)
+
+	if win.JustPressed(speedDownKey) {
+		speed.stepSpeed(-1)
+	}
+	if win.JustPressed(speedUpKey) {
+		speed.stepSpeed(1)
+	}
+	if win.JustPressed(speedResetKey) {
+		speed.speed = 1
+	}
+
+	if win.JustPressed(pauseKey) {
+		speed.togglePause()
+	}
+	if win.JustPressed(frameAdvanceKey) {
+		speed.frameAdvance()
+	}
+
+	return speed.status() != before.status()
+}
+
+// Failing to save or load a state isn't fatal, so errors are just reported
+func handleStateHotkeys(win *pixelgl.Window, m *gamebert.Machine, romPath string) {
+	shift := shiftPressed(win)
+
+	for i, key := range stateKeys {
+		if !win.JustPressed(key) {
+			continue
+		}
+
+		slot := i + 1
+		fpath := gamebert.StatePath(romPath, slot)
+		if shift {
+			if err := m.SaveStateFile(fpath); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to save state %d: %v\n", slot, err)
+			} else {
+				fmt.Printf("Saved state %d\n", slot)
+			}
+		} else {
+			if err := m.LoadStateFile(fpath); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to load state %d: %v\n", slot, err)
+			} else {
+				fmt.Printf("Loaded state %d\n", slot)
+			}
+		}
+	}
+}
+
+func handleGBSHotkeys(win *pixelgl.Window, p *gamebert.GBSPlayer) {
+	titleChanged := handleChannelHotkeys(win, p.APU())
+
+	if win.JustPressed(pixelgl.KeyRight) {
+		p.NextSong()
+		titleChanged = true
+	}
+	if win.JustPressed(pixelgl.KeyLeft) {
+		p.PrevSong()
+		titleChanged = true
+	}
+
+	if titleChanged {
+		win.SetTitle(windowTitle(p.Title(), p.APU(), nil))
+	}
+}
+
+// handleChannelHotkeys returns whether any channels were toggled
+func handleChannelHotkeys(win *pixelgl.Window, apu *gamebert.APU) bool {
+	toggled := false
+	for i, key := range channelKeys {
+		if win.JustPressed(key) {
+			apu.ToggleChannel(i)
+			toggled = true
+		}
+	}
+	return toggled
+}
+
+// windowTitle adds any state that isn't visible on screen to title. speed
+// may be nil.
+func windowTitle(title string, apu *gamebert.APU, speed *speedControl) string {
+	if speed != nil {
+		if status := speed.status(); status != "" {
+			title += " (" + status + ")"
+		}
+	}
+
+	var muted []string
+	for i, m := range apu.MutedChannels() {
+		if m {
+			muted = append(muted, strconv.Itoa(i+1))
+		}
+	}
+	if len(muted) > 0 {
+		title += " (muted channels: " + strings.Join(muted, ", ") + ")"
+	}
+
+	return title
+}
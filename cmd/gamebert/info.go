@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/robert/gamebert"
+)
+
+// printROMInfo prints the parsed header of the ROM at fpath and the result
+// of checking it. It returns false if any check failed.
+func printROMInfo(w io.Writer, fpath string) (bool, error) {
+	data, err := gamebert.ReadROMFile(fpath)
+	if err != nil {
+		return false, err
+	}
+
+	row := func(name string, val interface{}) {
+		fmt.Fprintf(w, "%-16s %v\n", name+":", val)
+	}
+
+	if gamebert.IsGBS(data) {
+		h, _, err := gamebert.ParseGBS(data)
+		if err != nil {
+			return false, err
+		}
+
+		row("Title", h.Title)
+		row("Author", h.Author)
+		row("Copyright", h.Copyright)
+		row("Tracks", fmt.Sprintf("%d (starting at %d)", h.NumSongs, h.FirstSong))
+		if h.UsesTimer() {
+			row("Driven by", "timer")
+		} else {
+			row("Driven by", "vblank")
+		}
+		return true, nil
+	}
+
+	h, err := gamebert.ParseCartridgeHeader(data)
+	if err != nil {
+		return false, err
+	}
+
+	row("Title", h.Title)
+	if h.ManufacturerNew != "" {
+		row("Manufacturer", h.ManufacturerNew)
+	}
+	row("Licensee", h.Licensee())
+	row("Cartridge type", fmt.Sprintf("%s (%02X)", h.TypeName(), h.CartridgeType))
+	row("ROM size", fmt.Sprintf("%d KiB (%d banks)", h.ROMSize/1024, h.ROMSize/0x4000))
+	row("RAM size", fmt.Sprintf("%d KiB", h.RAMSize/1024))
+	row("Battery", h.HasBattery())
+	row("RTC", h.HasRTC())
+
+	cgb := "no"
+	if h.IsCGBOnly() {
+		cgb = "required"
+	} else if h.IsCGB() {
+		cgb = "supported"
+	}
+	row("CGB", cgb)
+	row("SGB", h.IsSGB())
+
+	destination := "Japan"
+	if h.Destination != 0 {
+		destination = "Overseas"
+	}
+	row("Destination", destination)
+	row("Version", h.Version)
+
+	check := func(ok bool) string {
+		if ok {
+			return "OK"
+		}
+		return "BAD"
+	}
+	row("Logo", check(gamebert.LogoValid(data)))
+	row("Header checksum", fmt.Sprintf("%02X %s", h.HeaderChecksum, check(gamebert.ComputeHeaderChecksum(data) == h.HeaderChecksum)))
+	row("Global checksum", fmt.Sprintf("%04X %s", h.GlobalChecksum, check(gamebert.ComputeGlobalChecksum(data) == h.GlobalChecksum)))
+
+	errs := gamebert.ValidateROM(data, h)
+	for _, err := range errs {
+		fmt.Fprintln(w, "Warning:", err)
+	}
+
+	return len(errs) == 0, nil
+}
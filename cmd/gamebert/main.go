@@ -0,0 +1,565 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/robert/gamebert"
+)
+
+type options struct {
+	romPath     string
+	bootROMPath string
+	patchPath   string
+
+	scale   float64
+	palette Palette
+	mute    bool
+	speed   float64
+	// Stereo samples a second, for the speakers and recordings alike
+	sampleRate int
+
+	headless    bool
+	frames      int
+	screenshot  string
+	recordAudio string
+
+	// Indexed from 0, for sound channels 1-4
+	mutedChannels  [4]bool
+	recordChannels string
+
+	// GBS files only, 1-based
+	track int
+
+	// Save state slot to start from, 0 for none
+	loadState int
+	// Seconds of rewind history, 0 to disable rewinding
+	rewindSeconds int
+
+	illegalOpcodePolicy gamebert.IllegalOpcodePolicy
+}
+
+func usage(fs *flag.FlagSet) func() {
+	return func() {
+		out := fs.Output()
+		fmt.Fprintln(out, "Usage: gamebert [flags] <rom or gbs>")
+		fmt.Fprintln(out, "       gamebert info <rom>")
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, "Flags:")
+		fs.PrintDefaults()
+	}
+}
+
+// The range of --sample-rate. Below 8kHz there's little left of the sound,
+// and above 192kHz there's nothing more to hear.
+const (
+	minSampleRate = 8000
+	maxSampleRate = 192000
+)
+
+func parseFlags(args []string) (*options, error) {
+	fs := flag.NewFlagSet("gamebert", flag.ContinueOnError)
+	fs.Usage = usage(fs)
+
+	opts := &options{}
+	fs.StringVar(&opts.bootROMPath, "bootrom", "", "Path to a DMG boot ROM to run before the game (default: skip it)")
+	fs.StringVar(&opts.patchPath, "patch", "", "IPS, UPS or BPS patch to apply to the ROM (default: look beside the ROM)")
+	fs.Float64Var(&opts.scale, "scale", 3.0, "Window scale factor")
+	paletteName := fs.String("palette", "grey", "Palette: grey, bw, green, pocket, or 4 comma-separated hex colors")
+	fs.BoolVar(&opts.mute, "mute", false, "Disable sound")
+	fs.IntVar(&opts.sampleRate, "sample-rate", gamebert.DefaultSampleRate, "Sound output rate in Hz, for playing and recording")
+	fs.Float64Var(&opts.speed, "speed", 1.0, "Emulation speed multiplier")
+	fs.BoolVar(&opts.headless, "headless", false, "Run without a window (requires --frames)")
+	fs.IntVar(&opts.frames, "frames", 0, "Exit after this many frames (0 runs forever)")
+	fs.StringVar(&opts.screenshot, "screenshot", "", "Write a PNG of the last frame to this path on exit")
+	fs.StringVar(&opts.recordAudio, "record-audio", "", "Record sound to this WAV file")
+	mutedChannels := fs.String("mute-channels", "", "Comma-separated sound channels (1-4) to mute")
+	fs.IntVar(&opts.loadState, "load-state", 0, fmt.Sprintf("Start from this save state slot (1-%d)", gamebert.SaveStateSlots))
+	fs.IntVar(&opts.rewindSeconds, "rewind", 10, "Seconds of history to keep for rewinding (0 disables it)")
+	illegalOpcode := fs.String("illegal-opcode", "lockup", "What illegal opcodes do: lockup (like the hardware), break (pause, and say where), or stop")
+	fs.IntVar(&opts.track, "track", 0, "For GBS files, the track to start on (default: the file's first track)")
+	fs.StringVar(&opts.recordChannels, "record-channels", "", "Record each sound channel to its own WAV file, named `prefix`-ch1.wav to -ch4.wav")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return nil, errors.New("Expected exactly one ROM path")
+	}
+	opts.romPath = fs.Arg(0)
+
+	palette, err := ParsePalette(*paletteName)
+	if err != nil {
+		return nil, err
+	}
+	opts.palette = palette
+
+	opts.mutedChannels, err = parseChannelList(*mutedChannels)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.illegalOpcodePolicy, err = parseIllegalOpcodePolicy(*illegalOpcode)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.scale <= 0 {
+		return nil, fmt.Errorf("--scale must be positive, got %v", opts.scale)
+	}
+	if opts.speed <= 0 {
+		return nil, fmt.Errorf("--speed must be positive, got %v", opts.speed)
+	}
+	if opts.sampleRate < minSampleRate || opts.sampleRate > maxSampleRate {
+		return nil, fmt.Errorf("--sample-rate must be from %d to %d, got %d", minSampleRate, maxSampleRate, opts.sampleRate)
+	}
+	if opts.track < 0 {
+		return nil, fmt.Errorf("--track must not be negative, got %d", opts.track)
+	}
+	if opts.loadState < 0 || opts.loadState > gamebert.SaveStateSlots {
+		return nil, fmt.Errorf("--load-state must be a slot from 1 to %d, got %d", gamebert.SaveStateSlots, opts.loadState)
+	}
+	if opts.rewindSeconds < 0 {
+		return nil, fmt.Errorf("--rewind must not be negative, got %d", opts.rewindSeconds)
+	}
+	if opts.frames < 0 {
+		return nil, fmt.Errorf("--frames must not be negative, got %d", opts.frames)
+	}
+	if opts.headless && opts.frames == 0 {
+		return nil, errors.New("--headless needs --frames, or it would never exit")
+	}
+
+	return opts, nil
+}
+
+// parseChannelList parses a list of sound channels like "1,3"
+func parseChannelList(s string) ([4]bool, error) {
+	var channels [4]bool
+	if s == "" {
+		return channels, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		ch, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || ch < 1 || ch > 4 {
+			return channels, fmt.Errorf("Invalid sound channel %q, expected 1-4", part)
+		}
+		channels[ch-1] = true
+	}
+	return channels, nil
+}
+
+var illegalOpcodePolicies = map[string]gamebert.IllegalOpcodePolicy{
+	"stop":   gamebert.IllegalOpcodeStop,
+	"lockup": gamebert.IllegalOpcodeLockUp,
+	"break":  gamebert.IllegalOpcodeBreak,
+}
+
+func parseIllegalOpcodePolicy(s string) (gamebert.IllegalOpcodePolicy, error) {
+	policy, ok := illegalOpcodePolicies[s]
+	if !ok {
+		return 0, fmt.Errorf("Invalid --illegal-opcode %q, expected lockup, break or stop", s)
+	}
+	return policy, nil
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "info" {
+		if len(os.Args) != 3 {
+			fmt.Fprintln(os.Stderr, "Usage: gamebert info <rom>")
+			os.Exit(2)
+		}
+
+		ok, err := printROMInfo(os.Stdout, os.Args[2])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	opts, err := parseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if opts.headless {
+		err = run(opts)
+	} else {
+		pixelgl.Run(func() {
+			err = run(opts)
+		})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func loadBootROM(fpath string) ([]byte, error) {
+	data, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load boot ROM: %w", err)
+	}
+	if len(data) != 0x100 {
+		return nil, fmt.Errorf("Boot ROM %s should be 256 bytes, got %d", fpath, len(data))
+	}
+	return data, nil
+}
+
+func run(opts *options) (err error) {
+	data, warnings, err := gamebert.LoadROM(opts.romPath, opts.patchPath)
+	if err != nil {
+		return fmt.Errorf("Failed to load ROM: %w", err)
+	}
+	if gamebert.IsGBS(data) {
+		return runGBS(opts, data)
+	}
+	for _, err := range warnings {
+		fmt.Fprintln(os.Stderr, "Warning:", err)
+	}
+
+	var bootROM []byte
+	if opts.bootROMPath != "" {
+		bootROM, err = loadBootROM(opts.bootROMPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	var win *pixelgl.Window
+	var d *Display
+	if !opts.headless {
+		win, err = newWindow(opts)
+		if err != nil {
+			return err
+		}
+
+		d = &Display{
+			scale:   opts.scale,
+			win:     win,
+			palette: opts.palette,
+		}
+	}
+
+	machineOpts := []gamebert.Option{
+		gamebert.WithBootROM(bootROM),
+		gamebert.WithIllegalOpcodePolicy(opts.illegalOpcodePolicy),
+		gamebert.WithSampleRate(opts.sampleRate),
+	}
+	if win != nil {
+		machineOpts = append(machineOpts, gamebert.WithInput(windowInput{win}))
+	}
+	m, err := gamebert.New(data, machineOpts...)
+	if err != nil {
+		return fmt.Errorf("Failed to load ROM: %w", err)
+	}
+	m.APU().SetMutedChannels(opts.mutedChannels)
+
+	save, err := gamebert.OpenSaveFile(opts.romPath, m)
+	if err != nil {
+		return fmt.Errorf("Failed to load save: %w", err)
+	}
+	if save != nil {
+		// However we leave, so that nothing the game saved is lost
+		defer func() {
+			if flushErr := save.Flush(); flushErr != nil {
+				if err == nil {
+					err = fmt.Errorf("Failed to write save: %w", flushErr)
+				} else {
+					fmt.Fprintln(os.Stderr, "Failed to write save:", flushErr)
+				}
+			}
+		}()
+	}
+
+	speed := newSpeedControl(opts.speed)
+	if opts.loadState > 0 {
+		if err := m.LoadStateFile(gamebert.StatePath(opts.romPath, opts.loadState)); err != nil {
+			return fmt.Errorf("Failed to load state %d: %w", opts.loadState, err)
+		}
+	}
+	if win != nil {
+		win.SetTitle(windowTitle("Gamebert", m.APU(), speed))
+	}
+
+	audio, err := openAudio(opts)
+	if err != nil {
+		return err
+	}
+	defer audio.Close()
+	m.APU().SetCaptureChannels(audio.channelSinks != nil)
+
+	// There's no way to ask for a rewind without a window
+	var rewinder *gamebert.Rewinder
+	if win != nil && opts.rewindSeconds > 0 {
+		rewinder = gamebert.NewRewinder(m, opts.rewindSeconds)
+	}
+
+	lastDraw := time.Now()
+	frames := 0
+	// Why the machine stopped, if it has. With a window, it's left up so
+	// that the game can be rewound or a state loaded.
+	var stopped error
+	lockedUp := false
+
+	for win == nil || !win.Closed() {
+		if rewinder != nil && win.Pressed(rewindKey) {
+			if _, err := rewinder.Rewind(); err != nil {
+				return fmt.Errorf("Failed to rewind: %w", err)
+			}
+		} else if speed.runFrame() {
+			if err := m.RunFrame(); errors.Is(err, gamebert.ErrBreak) {
+				// Pause, so that the game can be stepped through with
+				// frame advance from here
+				fmt.Fprintln(os.Stderr, err)
+				lockedUp = m.LockedUp()
+				if win != nil && !speed.paused {
+					speed.togglePause()
+				}
+			} else if err != nil {
+				if win == nil {
+					stopped = err
+					break
+				}
+				if stopped == nil {
+					fmt.Fprintln(os.Stderr, "Emulation stopped:", err)
+					win.SetTitle("Gamebert - stopped")
+				}
+				stopped = err
+			} else {
+				stopped = nil
+				if m.LockedUp() && !lockedUp {
+					fmt.Fprintln(os.Stderr, "The CPU has locked up on an illegal opcode")
+				}
+				lockedUp = m.LockedUp()
+				speed.frameRan(time.Now())
+				frames++
+				if rewinder != nil {
+					if err := rewinder.Frame(); err != nil {
+						return fmt.Errorf("Failed to record rewind history: %w", err)
+					}
+				}
+			}
+		}
+
+		if d != nil {
+			frameLength := speed.frameLength()
+			tSinceLastDraw := time.Since(lastDraw)
+			tToNextDraw := frameLength - tSinceLastDraw
+
+			if tToNextDraw > 0 {
+				time.Sleep(tToNextDraw)
+			}
+
+			// Unthrottled, only draw often enough to look smooth. Hotkeys
+			// are only seen after the window updates.
+			if frameLength > 0 || tSinceLastDraw >= fastForwardDrawInterval {
+				lastDraw = time.Now()
+				d.draw(m.Framebuffer())
+				handleHotkeys(win, m, opts.romPath, speed)
+			}
+		}
+
+		if err := audio.frame(m.APU(), speed.audioSpeed()); err != nil {
+			return err
+		}
+
+		if save != nil {
+			if err := save.Tick(); err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to write save:", err)
+			}
+		}
+
+		if opts.frames > 0 && frames >= opts.frames {
+			break
+		}
+	}
+
+	if opts.screenshot != "" {
+		if err := writeScreenshot(opts.screenshot, m.Framebuffer(), opts.palette); err != nil {
+			return fmt.Errorf("Failed to write screenshot: %w", err)
+		}
+	}
+
+	if stopped != nil {
+		return fmt.Errorf("Emulation stopped: %w", stopped)
+	}
+	return nil
+}
+
+// runGBS plays a GBS file, with Left and Right stepping through tracks. The
+// window stays blank, since the LCD is never switched on.
+func runGBS(opts *options, data []byte) error {
+	player, err := gamebert.NewGBSPlayer(data, gamebert.WithSampleRate(opts.sampleRate))
+	if err != nil {
+		return fmt.Errorf("Failed to load GBS: %w", err)
+	}
+	numSongs := int(player.Header().NumSongs)
+	if opts.track > numSongs {
+		return fmt.Errorf("--track %d is out of range, there are %d tracks", opts.track, numSongs)
+	}
+	if opts.track > 0 {
+		player.StartSong(opts.track - 1)
+	}
+	player.APU().SetMutedChannels(opts.mutedChannels)
+
+	var win *pixelgl.Window
+	if !opts.headless {
+		win, err = newWindow(opts)
+		if err != nil {
+			return err
+		}
+		win.SetTitle(windowTitle(player.Title(), player.APU(), nil))
+	}
+
+	audio, err := openAudio(opts)
+	if err != nil {
+		return err
+	}
+	defer audio.Close()
+	player.APU().SetCaptureChannels(audio.channelSinks != nil)
+
+	frameLength := time.Duration((1.0 / float64(gamebert.FramesPerSecond)) * float64(time.Second) / opts.speed)
+
+	lastFrame := time.Now()
+	frames := 0
+
+	for win == nil || !win.Closed() {
+		if err := player.RunFrame(); err != nil {
+			return fmt.Errorf("Playback stopped: %w", err)
+		}
+		frames++
+
+		if err := audio.frame(player.APU(), opts.speed); err != nil {
+			return err
+		}
+
+		if win != nil {
+			if tToNextFrame := frameLength - time.Since(lastFrame); tToNextFrame > 0 {
+				time.Sleep(tToNextFrame)
+			}
+			lastFrame = time.Now()
+
+			win.Clear(color.Black)
+			win.Update()
+			handleGBSHotkeys(win, player)
+		}
+
+		if opts.frames > 0 && frames >= opts.frames {
+			break
+		}
+	}
+
+	return nil
+}
+
+func newWindow(opts *options) (*pixelgl.Window, error) {
+	cfg := &pixelgl.WindowConfig{
+		Title:  "Gamebert",
+		Bounds: pixel.R(0, 0, float64(gamebert.ScreenWidth)*opts.scale, float64(gamebert.ScreenHeight)*opts.scale),
+		VSync:  true,
+	}
+	return pixelgl.NewWindow(*cfg)
+}
+
+// audioOutput is everywhere the APU's samples go each frame
+type audioOutput struct {
+	sinks []AudioSink
+	// The sink playing in real time, whose buffer we keep fed, or nil if
+	// there isn't one
+	realtime audioBufferer
+	// One per sound channel, or nil if they aren't being recorded
+	channelSinks []AudioSink
+}
+
+// openAudio opens the speakers, unless muted or headless, and any WAV
+// recordings asked for.
+func openAudio(opts *options) (*audioOutput, error) {
+	a := &audioOutput{}
+
+	if !opts.mute && !opts.headless {
+		host, err := NewHostAudio(opts.sampleRate)
+		if err != nil {
+			// Better to carry on in silence than not at all
+			fmt.Fprintln(os.Stderr, "Warning: no sound:", err)
+		} else {
+			a.sinks = append(a.sinks, host)
+		}
+	}
+
+	if opts.recordAudio != "" {
+		wav, err := gamebert.CreateWAVFile(opts.recordAudio, opts.sampleRate)
+		if err != nil {
+			a.Close()
+			return nil, fmt.Errorf("Failed to record audio: %w", err)
+		}
+		a.sinks = append(a.sinks, wav)
+	}
+
+	for _, sink := range a.sinks {
+		if b, ok := sink.(audioBufferer); ok {
+			a.realtime = b
+		}
+	}
+
+	if opts.recordChannels != "" {
+		prefix := strings.TrimSuffix(opts.recordChannels, ".wav")
+		for ch := 1; ch <= 4; ch++ {
+			wav, err := gamebert.CreateWAVFile(fmt.Sprintf("%s-ch%d.wav", prefix, ch), opts.sampleRate)
+			if err != nil {
+				a.Close()
+				return nil, fmt.Errorf("Failed to record audio: %w", err)
+			}
+			a.channelSinks = append(a.channelSinks, wav)
+		}
+	}
+
+	return a, nil
+}
+
+// frame hands over the samples from the last frame, and adjusts the APU's
+// rate to keep the speakers fed.
+func (a *audioOutput) frame(apu *gamebert.APU, speed float64) error {
+	samples := apu.TakeSamples()
+	for _, sink := range a.sinks {
+		if err := sink.WriteSamples(samples); err != nil {
+			return fmt.Errorf("Failed to write audio: %w", err)
+		}
+	}
+	for i, sink := range a.channelSinks {
+		if err := sink.WriteSamples(apu.TakeChannelSamples(i)); err != nil {
+			return fmt.Errorf("Failed to write audio: %w", err)
+		}
+	}
+
+	if a.realtime != nil {
+		apu.SetRateScale(speed * rateControl(a.realtime.bufferFill()))
+	}
+	return nil
+}
+
+func (a *audioOutput) Close() {
+	for _, sink := range append(a.sinks, a.channelSinks...) {
+		if err := sink.Close(); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to close audio:", err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robert/gamebert"
+)
+
+// The speeds that the speed up and down hotkeys step through
+var speedSteps = []float64{0.125, 0.25, 0.5, 0.75, 1, 1.5, 2, 3, 4, 8}
+
+// How often to draw while fast-forwarding. The window waits for vsync, so
+// drawing every frame would hold us to the monitor's refresh rate.
+const fastForwardDrawInterval = time.Second / gamebert.FramesPerSecond
+
+// speedControl decides how fast the run loop goes. The speed is a multiple
+// of real time, which fast-forward overrides by running flat out. Paused,
+// no frames run at all, apart from one at a time with frame advance.
+type speedControl struct {
+	speed float64
+
+	fastForwardHeld    bool
+	fastForwardToggled bool
+
+	paused  bool
+	advance bool
+
+	// How fast we're actually going, averaged over recent frames, for
+	// when we aren't throttled to a known speed
+	measured  float64
+	lastFrame time.Time
+}
+
+func newSpeedControl(speed float64) *speedControl {
+	return &speedControl{
+		speed:    speed,
+		measured: speed,
+	}
+}
+
+func (s *speedControl) fastForwarding() bool {
+	return s.fastForwardHeld || s.fastForwardToggled
+}
+
+// runFrame returns whether to run a frame this time around the loop
+func (s *speedControl) runFrame() bool {
+	if !s.paused {
+		return true
+	}
+	if s.advance {
+		s.advance = false
+		return true
+	}
+	return false
+}
+
+// frameRan updates the measured speed after running a frame
+func (s *speedControl) frameRan(now time.Time) {
+	if !s.lastFrame.IsZero() {
+		if elapsed := now.Sub(s.lastFrame); elapsed > 0 {
+			speed := float64(time.Second/gamebert.FramesPerSecond) / float64(elapsed)
+			s.measured += (speed - s.measured) * 0.1
+		}
+	}
+	s.lastFrame = now
+}
+
+// frameLength is how long each frame should take, or 0 to run unthrottled.
+// While paused it keeps the loop ticking over at the normal rate.
+func (s *speedControl) frameLength() time.Duration {
+	if s.paused {
+		return time.Second / gamebert.FramesPerSecond
+	}
+	if s.fastForwarding() {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / gamebert.FramesPerSecond / s.speed)
+}
+
+// audioSpeed is how much faster than real time sound is being produced, so
+// that the APU can space out its samples to match.
+func (s *speedControl) audioSpeed() float64 {
+	if s.fastForwarding() {
+		return s.measured
+	}
+	return s.speed
+}
+
+func (s *speedControl) togglePause() {
+	s.paused = !s.paused
+	s.advance = false
+	s.lastFrame = time.Time{}
+}
+
+// frameAdvance pauses, and runs a single frame
+func (s *speedControl) frameAdvance() {
+	s.paused = true
+	s.advance = true
+}
+
+// stepSpeed moves to the next speed step up (dir > 0) or down (dir < 0)
+func (s *speedControl) stepSpeed(dir int) {
+	if dir > 0 {
+		for _, step := range speedSteps {
+			if step > s.speed {
+				s.speed = step
+				return
+			}
+		}
+	} else {
+		for i := len(speedSteps) - 1; i >= 0; i-- {
+			if speedSteps[i] < s.speed {
+				s.speed = speedSteps[i]
+				return
+			}
+		}
+	}
+}
+
+// status describes anything other than running at normal speed, for the
+// window title
+func (s *speedControl) status() string {
+	switch {
+	case s.paused:
+		return "paused"
+	case s.fastForwarding():
+		return "fast-forward"
+	case s.speed != 1:
+		return fmt.Sprintf("%gx speed", s.speed)
+	}
+	return ""
+}
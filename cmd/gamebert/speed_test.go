@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robert/gamebert"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpeedSteps(t *testing.T) {
+	s := newSpeedControl(1)
+	s.stepSpeed(1)
+	assert.Equal(t, 1.5, s.speed)
+	s.stepSpeed(-1)
+	s.stepSpeed(-1)
+	assert.Equal(t, 0.75, s.speed)
+	assert.Equal(t, "0.75x speed", s.status())
+
+	// Speeds from --speed that fall between steps go to the nearest one
+	s = newSpeedControl(1.2)
+	s.stepSpeed(-1)
+	assert.Equal(t, 1.0, s.speed)
+
+	// And stop at the ends
+	s = newSpeedControl(8)
+	s.stepSpeed(1)
+	assert.Equal(t, 8.0, s.speed)
+	assert.Equal(t, 2*time.Millisecond, s.frameLength().Round(time.Millisecond))
+}
+
+func TestPauseAndFrameAdvance(t *testing.T) {
+	s := newSpeedControl(1)
+	assert.True(t, s.runFrame())
+
+	s.togglePause()
+	assert.Equal(t, "paused", s.status())
+	assert.False(t, s.runFrame())
+	assert.False(t, s.runFrame())
+
+	s.frameAdvance()
+	assert.True(t, s.runFrame())
+	assert.False(t, s.runFrame())
+
+	// Frame advance pauses if we weren't already
+	s.togglePause()
+	assert.True(t, s.runFrame())
+	s.frameAdvance()
+	assert.True(t, s.runFrame())
+	assert.False(t, s.runFrame())
+}
+
+func TestFastForward(t *testing.T) {
+	s := newSpeedControl(0.5)
+	assert.Equal(t, 0.5, s.audioSpeed())
+
+	s.fastForwardHeld = true
+	assert.Equal(t, time.Duration(0), s.frameLength())
+	assert.Equal(t, "fast-forward", s.status())
+
+	// Audio follows how fast frames are actually running, here 4x
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		s.frameRan(now)
+		now = now.Add(time.Second / gamebert.FramesPerSecond / 4)
+	}
+	assert.InDelta(t, 4.0, s.audioSpeed(), 0.01)
+
+	s.fastForwardHeld = false
+	assert.Equal(t, 0.5, s.audioSpeed())
+	assert.Equal(t, 33*time.Millisecond, s.frameLength().Truncate(time.Millisecond))
+}
@@ -0,0 +1,28 @@
+package gamebert
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegmentBounds(t *testing.T) {
+	ram := newRAMSegment(0x10)
+	ram.write(uint8(0x0F), 0x42)
+	assert.Equal(t, uint8(0x42), ram.read(uint64(0x0F)))
+
+	// Out of range reads are open bus, and writes go nowhere
+	ram.write(uint16(0x10), 0x42)
+	assert.Equal(t, uint8(0xFF), ram.read(uint16(0x10)))
+	assert.Equal(t, uint8(0xFF), ram.read(uint32(0xFFFFFFFF)))
+
+	// As do locations that aren't unsigned
+	ram.write(0x00, 0x42)
+	assert.Equal(t, uint8(0x00), ram.read(uint8(0x00)))
+	assert.Equal(t, uint8(0xFF), ram.read(-1))
+
+	rom := newROMSegment([]uint8{0x01, 0x02})
+	assert.Equal(t, uint8(0x02), rom.read(uint16(1)))
+	assert.Equal(t, uint8(0xFF), rom.read(uint16(2)))
+	assert.Equal(t, uint8(0xFF), rom.read("0"))
+}
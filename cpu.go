@@ -1,81 +1,88 @@
-package main
+package gamebert
 
 import (
 	"fmt"
 )
 
-var opcodes = LoadOpcodes()
-
-type CPU struct {
-	a *Register8Bit
-	b *Register8Bit
-	c *Register8Bit
-	d *Register8Bit
-	e *Register8Bit
-	f *Register8Bit
-	h *Register8Bit
-	l *Register8Bit
-
-	bc *UnifiedRegister16Bit
-	de *UnifiedRegister16Bit
-	hl *UnifiedRegister16Bit
-	af *UnifiedRegister16Bit
-
-	zFlag *Flag
-	nFlag *Flag
-	hFlag *Flag
-	cFlag *Flag
-
-	pc *Register16Bit
-	sp *Register16Bit
-
-	interruptsTriggered *Register8Bit
-	interruptsEnabled   *Register8Bit
-
-	intTriggeredVBlank *Flag
-	intTriggeredStat   *Flag
-	intTriggeredTimer  *Flag
-	intTriggeredSerial *Flag
-	intTriggeredJoypad *Flag
-
-	intEnabledVBlank *Flag
-	intEnabledStat   *Flag
-	intEnabledTimer  *Flag
-	intEnabledSerial *Flag
-	intEnabledJoypad *Flag
+var opcodes = mustLoadOpcodes()
+
+type cpu struct {
+	a *register8Bit
+	b *register8Bit
+	c *register8Bit
+	d *register8Bit
+	e *register8Bit
+	f *register8Bit
+	h *register8Bit
+	l *register8Bit
+
+	bc *unifiedRegister16Bit
+	de *unifiedRegister16Bit
+	hl *unifiedRegister16Bit
+	af *unifiedRegister16Bit
+
+	zFlag *flag
+	nFlag *flag
+	hFlag *flag
+	cFlag *flag
+
+	pc *register16Bit
+	sp *register16Bit
+
+	interruptsTriggered *register8Bit
+	interruptsEnabled   *register8Bit
+
+	intTriggeredVBlank *flag
+	intTriggeredStat   *flag
+	intTriggeredTimer  *flag
+	intTriggeredSerial *flag
+	intTriggeredJoypad *flag
+
+	intEnabledVBlank *flag
+	intEnabledStat   *flag
+	intEnabledTimer  *flag
+	intEnabledSerial *flag
+	intEnabledJoypad *flag
 
 	masterInterruptsEnabled bool
 	halted                  bool
-
-	mb *Motherboard
+	// Set by the HALT bug, for the next instruction to fail to increment
+	// PC past its opcode
+	haltBug bool
+	// Hung on an illegal opcode, until the machine is reset
+	lockedUp bool
+	// In STOP mode, until a button is pressed
+	stopped bool
+
+	mb *motherboard
 }
 
-func NewCPU(motherboard *Motherboard) *CPU {
-	a := &Register8Bit{name: "a"}
-	b := &Register8Bit{name: "b"}
-	c := &Register8Bit{name: "c"}
-	d := &Register8Bit{name: "d"}
-	e := &Register8Bit{name: "e"}
-	f := &Register8Bit{name: "f", mask: 0b11110000}
-	h := &Register8Bit{name: "h"}
-	l := &Register8Bit{name: "l"}
+func newCPU(motherboard *motherboard) *cpu {
+	a := &register8Bit{name: "a"}
+	b := &register8Bit{name: "b"}
+	c := &register8Bit{name: "c"}
+	d := &register8Bit{name: "d"}
+	e := &register8Bit{name: "e"}
+	f := &register8Bit{name: "f", mask: 0b11110000}
+	h := &register8Bit{name: "h"}
+	l := &register8Bit{name: "l"}
 
-	bc := &UnifiedRegister16Bit{hi: b, lo: c}
-	de := &UnifiedRegister16Bit{hi: d, lo: e}
-	hl := &UnifiedRegister16Bit{hi: h, lo: l}
-	af := &UnifiedRegister16Bit{hi: a, lo: f}
+	bc := &unifiedRegister16Bit{hi: b, lo: c}
+	de := &unifiedRegister16Bit{hi: d, lo: e}
+	hl := &unifiedRegister16Bit{hi: h, lo: l}
+	af := &unifiedRegister16Bit{hi: a, lo: f}
 
-	zFlag := &Flag{reg: f, offset: 7, name: "z"}
-	nFlag := &Flag{reg: f, offset: 6, name: "n"}
-	hFlag := &Flag{reg: f, offset: 5, name: "h"}
-	cFlag := &Flag{reg: f, offset: 4, name: "c"}
+	zFlag := &flag{reg: f, offset: 7, name: "z"}
+	nFlag := &flag{reg: f, offset: 6, name: "n"}
+	hFlag := &flag{reg: f, offset: 5, name: "h"}
+	cFlag := &flag{reg: f, offset: 4, name: "c"}
 
-	pc := &Register16Bit{name: "pc"}
+	pc := &register16Bit{name: "pc"}
 
-	interruptsTriggered := &Register8Bit{}
-	interruptsEnabled := &Register8Bit{}
+	interruptsTriggered := &register8Bit{}
+	interruptsEnabled := &register8Bit{}
 
-	return &CPU{
+	return &cpu{
 		a: a,
 		b: b,
 		c: c,
@@ -96,22 +103,22 @@ func NewCPU(motherboard *Motherboard) *CPU {
 		cFlag: cFlag,
 
 		pc: pc,
-		sp: &Register16Bit{name: "sp"},
+		sp: &register16Bit{name: "sp"},
 
 		interruptsTriggered: interruptsTriggered,
 		interruptsEnabled:   interruptsEnabled,
 
-		intTriggeredVBlank: &Flag{reg: interruptsTriggered, offset: 0, name: "itvb"},
-		intTriggeredStat:   &Flag{reg: interruptsTriggered, offset: 1, name: "itst"},
-		intTriggeredTimer:  &Flag{reg: interruptsTriggered, offset: 2, name: "itti"},
-		intTriggeredSerial: &Flag{reg: interruptsTriggered, offset: 3, name: "itse"},
-		intTriggeredJoypad: &Flag{reg: interruptsTriggered, offset: 4, name: "itjo"},
+		intTriggeredVBlank: &flag{reg: interruptsTriggered, offset: 0, name: "itvb"},
+		intTriggeredStat:   &flag{reg: interruptsTriggered, offset: 1, name: "itst"},
+		intTriggeredTimer:  &flag{reg: interruptsTriggered, offset: 2, name: "itti"},
+		intTriggeredSerial: &flag{reg: interruptsTriggered, offset: 3, name: "itse"},
+		intTriggeredJoypad: &flag{reg: interruptsTriggered, offset: 4, name: "itjo"},
 
-		intEnabledVBlank: &Flag{reg: interruptsEnabled, offset: 0, name: "ievb"},
-		intEnabledStat:   &Flag{reg: interruptsEnabled, offset: 1, name: "iest"},
-		intEnabledTimer:  &Flag{reg: interruptsEnabled, offset: 2, name: "ieti"},
-		intEnabledSerial: &Flag{reg: interruptsEnabled, offset: 3, name: "iese"},
-		intEnabledJoypad: &Flag{reg: interruptsEnabled, offset: 4, name: "iejo"},
+		intEnabledVBlank: &flag{reg: interruptsEnabled, offset: 0, name: "ievb"},
+		intEnabledStat:   &flag{reg: interruptsEnabled, offset: 1, name: "iest"},
+		intEnabledTimer:  &flag{reg: interruptsEnabled, offset: 2, name: "ieti"},
+		intEnabledSerial: &flag{reg: interruptsEnabled, offset: 3, name: "iese"},
+		intEnabledJoypad: &flag{reg: interruptsEnabled, offset: 4, name: "iejo"},
 
 		masterInterruptsEnabled: true,
 		halted:                  false,
@@ -120,7 +127,7 @@ func NewCPU(motherboard *Motherboard) *CPU {
 	}
 }
 
-func (cpu *CPU) initToPostBootROM() {
+func (cpu *cpu) initToPostBootROM() {
 	cpu.a.write(0x01)
 	cpu.f.write(0xB0)
 	cpu.b.write(0x00)
@@ -131,16 +138,33 @@ func (cpu *CPU) initToPostBootROM() {
 	cpu.l.write(0x4D)
 	cpu.sp.write(0xFFFE)
 	cpu.pc.write(0x0100)
+
+	// The boot ROM never enables interrupts
+	cpu.masterInterruptsEnabled = false
 }
 
-func (cpu *CPU) tick() uint8 {
-	// Use a mask because only the first 5 bits of the interrupt flags
-	// are used.
-	mask := uint8(0b11111)
+func (cpu *cpu) tick() uint8 {
+	if cpu.lockedUp {
+		return 4
+	}
 
-	// Are any interrupts both triggered and enabled?
-	if (cpu.interruptsTriggered.read()&mask)&(cpu.interruptsEnabled.read()&mask) != 0 {
-		cpu.halted = false
+	// Only a button wakes the CPU from STOP. Interrupts wait until then.
+	if cpu.stopped {
+		if !cpu.mb.joypadIO.lineLow() {
+			return 4
+		}
+		cpu.stopped = false
+	}
+
+	// A pending interrupt wakes the CPU from HALT whether or not IME is
+	// set. With it set, the interrupt is serviced. Without, execution just
+	// carries on after the HALT.
+	var wakeCycles uint8
+	if cpu.interruptPending() {
+		if cpu.halted {
+			cpu.halted = false
+			wakeCycles = haltWakeCycles
+		}
 
 		cpu.maybeHandleInterrupt(cpu.intTriggeredVBlank, cpu.intEnabledVBlank, 0x0040)
 		cpu.maybeHandleInterrupt(cpu.intTriggeredStat, cpu.intEnabledStat, 0x0048)
@@ -150,15 +174,65 @@ func (cpu *CPU) tick() uint8 {
 	}
 
 	if !cpu.halted {
-		return cpu.fetchAndExecute()
+		return wakeCycles + cpu.fetchAndExecute()
 	} else {
 		return 4
 	}
 }
 
-func (cpu *CPU) maybeHandleInterrupt(triggeredFlag *Flag, enabledFlag *Flag, jumpToAddr uint16) bool {
+// How long the CPU takes to come out of HALT
+const haltWakeCycles = 4
+
+// halt runs HALT. If IME is clear and an interrupt is already pending, the
+// CPU doesn't halt, and instead hits the HALT bug: the next opcode is read
+// without PC moving past it, so the byte after HALT is read twice.
+// https://gbdev.io/pandocs/halt.html
+func (cpu *cpu) halt() {
+	if !cpu.masterInterruptsEnabled && cpu.interruptPending() {
+		cpu.haltBug = true
+		return
+	}
+	cpu.halted = true
+}
+
+// interruptPending is whether any interrupts are both triggered and enabled
+func (cpu *cpu) interruptPending() bool {
+	// Use a mask because only the first 5 bits of the interrupt flags
+	// are used.
+	mask := uint8(0b11111)
+	return (cpu.interruptsTriggered.read()&mask)&(cpu.interruptsEnabled.read()&mask) != 0
+}
+
+// stop runs STOP, which does different things depending on whether a
+// button is held and an interrupt is pending. There's no speed switch to
+// do, since that's CGB only.
+// https://gbdev.io/pandocs/Reducing_Power_Consumption.html#the-bizarre-case-of-the-game-boy-stop-instruction-before-even-considering-timing
+func (cpu *cpu) stop() {
+	pending := cpu.interruptPending()
+
+	// STOP is followed by a byte that it skips, except when an interrupt
+	// is pending. opcodes.json counts it as a 1 byte instruction, so the
+	// second byte is skipped here.
+	if cpu.mb.joypadIO.lineLow() {
+		// A held button would wake it straight away, so it doesn't stop
+		// at all. Without an interrupt to service, it halts instead.
+		if !pending {
+			cpu.pc.inc(1)
+			cpu.halted = true
+		}
+		return
+	}
+
+	if !pending {
+		cpu.pc.inc(1)
+	}
+	// As a write to DIV does
+	cpu.mb.timer.div.write(0)
+	cpu.stopped = true
+}
+
+func (cpu *cpu) maybeHandleInterrupt(triggeredFlag *flag, enabledFlag *flag, jumpToAddr uint16) bool {
 	if triggeredFlag.read() && enabledFlag.read() {
-		// TODO: handle halted
 		if cpu.masterInterruptsEnabled {
 			triggeredFlag.write(false)
 
@@ -177,35 +251,76 @@ func (cpu *CPU) maybeHandleInterrupt(triggeredFlag *Flag, enabledFlag *Flag, jum
 	}
 }
 
-func (cpu *CPU) fetchAndExecute() uint8 {
+func (cpu *cpu) fetchAndExecute() uint8 {
 	op := cpu.nextOp()
+	if op == nil {
+		// The fetch still took its cycle
+		return 4
+	}
 
-	cpu.pc.inc(op.bytesConsumed())
+	if cpu.haltBug {
+		cpu.haltBug = false
+		cpu.pc.inc(op.bytesConsumed() - 1)
+	} else {
+		cpu.pc.inc(op.bytesConsumed())
+	}
 	cycles := cpu.executeOp(op)
 
 	return cycles
 }
 
-func (cpu *CPU) nextOp() *operation {
+// nextOp decodes the instruction at PC. For an illegal opcode it returns
+// nil, leaving PC pointing at it.
+func (cpu *cpu) nextOp() *operation {
 	pc := cpu.pc.read()
 	opcodeAddr := cpu.mb.readByte(pc)
-	ann := opcodes.GetUnprefixed(opcodeAddr)
+	ann, err := opcodes.getUnprefixed(opcodeAddr)
+	if err != nil {
+		cpu.illegalOpcode(pc, opcodeAddr)
+		return nil
+	}
+
+	// Operands follow the opcode, except after the HALT bug, when PC
+	// hasn't moved past it and they start at the opcode itself
+	operandsPC := pc
+	if cpu.haltBug {
+		operandsPC--
+	}
 
-	var cbAnn *Opcode
+	var cbAnn *opcodeInfo
 	if opcodeAddr == 0xCB {
-		cbOpcodeAddr := cpu.mb.readByte(pc + 1)
-		cbAnn = opcodes.GetCbPrefixed(cbOpcodeAddr)
+		cbOpcodeAddr := cpu.mb.readByte(operandsPC + 1)
+		cbAnn, err = opcodes.getCbPrefixed(cbOpcodeAddr)
+		if err != nil {
+			cpu.mb.fault(err)
+			return nil
+		}
 	}
 
 	return &operation{
 		opcode:   ann,
 		cbOpcode: cbAnn,
-		pc:       pc,
+		pc:       operandsPC,
 		mb:       cpu.mb,
 	}
 }
 
-func (cpu *CPU) inc8(rw RW8Bit) {
+// illegalOpcode does whatever the IllegalOpcodePolicy says to
+func (cpu *cpu) illegalOpcode(pc uint16, opcode uint8) {
+	err := &IllegalOpcodeError{PC: pc, Opcode: opcode}
+
+	switch cpu.mb.illegalOpcodePolicy {
+	case IllegalOpcodeLockUp:
+		cpu.lockedUp = true
+	case IllegalOpcodeBreak:
+		cpu.lockedUp = true
+		cpu.mb.breakFor(err)
+	default:
+		cpu.mb.fault(err)
+	}
+}
+
+func (cpu *cpu) inc8(rw rw8Bit) {
 	oldVal := rw.read()
 	newVal := oldVal + 1
 
@@ -218,7 +333,7 @@ func (cpu *CPU) inc8(rw RW8Bit) {
 	cpu.hFlag.write(hFlag)
 }
 
-func (cpu *CPU) dec8(rw RW8Bit) {
+func (cpu *cpu) dec8(rw rw8Bit) {
 	oldVal := rw.read()
 	newVal := oldVal - 1
 
@@ -229,23 +344,23 @@ func (cpu *CPU) dec8(rw RW8Bit) {
 	cpu.hFlag.write(isHalfBorrow8(oldVal, 1))
 }
 
-func (cpu *CPU) inc16(rw RW16Bit) {
+func (cpu *cpu) inc16(rw rw16Bit) {
 	rw.write(rw.read() + 1)
 }
 
-func (cpu *CPU) dec16(rw RW16Bit) {
+func (cpu *cpu) dec16(rw rw16Bit) {
 	rw.write(rw.read() - 1)
 }
 
-func (cpu *CPU) ld8(dst RW8Bit, src R8Bit) {
+func (cpu *cpu) ld8(dst rw8Bit, src r8Bit) {
 	dst.write(src.read())
 }
 
-func (cpu *CPU) ld16(dst RW16Bit, src R16Bit) {
+func (cpu *cpu) ld16(dst rw16Bit, src r16Bit) {
 	dst.write(src.read())
 }
 
-func (cpu *CPU) jr(src R8Bit) {
+func (cpu *cpu) jr(src r8Bit) {
 	srcVal := src.read()
 
 	signedD8 := int8(srcVal)
@@ -257,23 +372,23 @@ func (cpu *CPU) jr(src R8Bit) {
 	}
 }
 
-func (cpu *CPU) jrCond(cond bool, src R8Bit) {
+func (cpu *cpu) jrCond(cond bool, src r8Bit) {
 	if cond {
 		cpu.jr(src)
 	}
 }
 
-func (cpu *CPU) jp(src R16Bit) {
+func (cpu *cpu) jp(src r16Bit) {
 	cpu.pc.write(src.read())
 }
 
-func (cpu *CPU) jpCond(cond bool, src R16Bit) {
+func (cpu *cpu) jpCond(cond bool, src r16Bit) {
 	if cond {
 		cpu.jp(src)
 	}
 }
 
-func (cpu *CPU) rl(src RW8Bit) {
+func (cpu *cpu) rl(src rw8Bit) {
 	rotated, oldBit7 := rotateThroughL8(src.read(), cpu.cFlag.read())
 	src.write(rotated)
 
@@ -283,7 +398,7 @@ func (cpu *CPU) rl(src RW8Bit) {
 	cpu.cFlag.write(oldBit7)
 }
 
-func (cpu *CPU) rla() {
+func (cpu *cpu) rla() {
 	rotated, oldBit7 := rotateThroughL8(cpu.a.read(), cpu.cFlag.read())
 	cpu.a.write(rotated)
 
@@ -293,7 +408,7 @@ func (cpu *CPU) rla() {
 	cpu.cFlag.write(oldBit7)
 }
 
-func (cpu *CPU) rlc(src RW8Bit) {
+func (cpu *cpu) rlc(src rw8Bit) {
 	rotated := rotateL8(src.read())
 	src.write(rotated)
 
@@ -303,7 +418,7 @@ func (cpu *CPU) rlc(src RW8Bit) {
 	cpu.cFlag.write(isBitSet8(rotated, 0))
 }
 
-func (cpu *CPU) rlca() {
+func (cpu *cpu) rlca() {
 	rotated := rotateL8(cpu.a.read())
 	cpu.a.write(rotated)
 
@@ -313,7 +428,7 @@ func (cpu *CPU) rlca() {
 	cpu.cFlag.write(isBitSet8(rotated, 0))
 }
 
-func (cpu *CPU) rrc(src RW8Bit) {
+func (cpu *cpu) rrc(src rw8Bit) {
 	rotated := rotateR8(src.read())
 	src.write(rotated)
 
@@ -323,7 +438,7 @@ func (cpu *CPU) rrc(src RW8Bit) {
 	cpu.cFlag.write(isBitSet8(rotated, 7))
 }
 
-func (cpu *CPU) rrca() {
+func (cpu *cpu) rrca() {
 	rotated := rotateR8(cpu.a.read())
 	cpu.a.write(rotated)
 
@@ -333,7 +448,7 @@ func (cpu *CPU) rrca() {
 	cpu.cFlag.write(isBitSet8(rotated, 7))
 }
 
-func (cpu *CPU) rr(src RW8Bit) {
+func (cpu *cpu) rr(src rw8Bit) {
 	newVal, oldBit0 := rotateThroughR8(src.read(), cpu.cFlag.read())
 	src.write(newVal)
 
@@ -343,7 +458,7 @@ func (cpu *CPU) rr(src RW8Bit) {
 	cpu.cFlag.write(oldBit0)
 }
 
-func (cpu *CPU) sla(src RW8Bit) {
+func (cpu *cpu) sla(src rw8Bit) {
 	newVal, oldBit7 := shiftL8(src.read())
 	src.write(newVal)
 
@@ -353,7 +468,7 @@ func (cpu *CPU) sla(src RW8Bit) {
 	cpu.cFlag.write(oldBit7)
 }
 
-func (cpu *CPU) sra(src RW8Bit) {
+func (cpu *cpu) sra(src rw8Bit) {
 	oldVal := src.read()
 	newVal, oldBit0 := shiftR8(oldVal)
 	if isBitSet8(oldVal, 7) {
@@ -367,7 +482,7 @@ func (cpu *CPU) sra(src RW8Bit) {
 	cpu.cFlag.write(oldBit0)
 }
 
-func (cpu *CPU) srl(src RW8Bit) {
+func (cpu *cpu) srl(src rw8Bit) {
 	newVal, oldBit0 := shiftR8(src.read())
 	src.write(newVal)
 
@@ -377,7 +492,7 @@ func (cpu *CPU) srl(src RW8Bit) {
 	cpu.cFlag.write(oldBit0)
 }
 
-func (cpu *CPU) swap(src RW8Bit) {
+func (cpu *cpu) swap(src rw8Bit) {
 	oldVal := src.read()
 	newVal := oldVal>>4 + (oldVal&0xF)<<4
 
@@ -389,7 +504,7 @@ func (cpu *CPU) swap(src RW8Bit) {
 	cpu.cFlag.write(false)
 }
 
-func (cpu *CPU) cp(src R8Bit) {
+func (cpu *cpu) cp(src r8Bit) {
 	a := cpu.a.read()
 	srcVal := src.read()
 
@@ -399,7 +514,7 @@ func (cpu *CPU) cp(src R8Bit) {
 	cpu.cFlag.write(a < srcVal)
 }
 
-func (cpu *CPU) add8(dst RW8Bit, src R8Bit) {
+func (cpu *cpu) add8(dst rw8Bit, src r8Bit) {
 	oldDstVal := dst.read()
 	srcVal := src.read()
 
@@ -413,7 +528,7 @@ func (cpu *CPU) add8(dst RW8Bit, src R8Bit) {
 	cpu.cFlag.write(newDstVal < oldDstVal)
 }
 
-func (cpu *CPU) adc(dst RW8Bit, src R8Bit) {
+func (cpu *cpu) adc(dst rw8Bit, src r8Bit) {
 	oldDstVal := dst.read()
 	srcVal := src.read()
 
@@ -434,7 +549,7 @@ func (cpu *CPU) adc(dst RW8Bit, src R8Bit) {
 	cpu.cFlag.write(newDstVal <= oldDstVal && (srcVal != 0 || cFlagVal != 0))
 }
 
-func (cpu *CPU) add16(dst RW16Bit, src R16Bit) {
+func (cpu *cpu) add16(dst rw16Bit, src r16Bit) {
 	oldDstVal := dst.read()
 	srcVal := src.read()
 
@@ -450,7 +565,7 @@ func (cpu *CPU) add16(dst RW16Bit, src R16Bit) {
 	cpu.cFlag.write(cFlag)
 }
 
-func (cpu *CPU) or(src R8Bit) {
+func (cpu *cpu) or(src r8Bit) {
 	newA := cpu.a.read() | src.read()
 
 	cpu.a.write(newA)
@@ -461,7 +576,7 @@ func (cpu *CPU) or(src R8Bit) {
 	cpu.cFlag.write(false)
 }
 
-func (cpu *CPU) xor(src R8Bit) {
+func (cpu *cpu) xor(src r8Bit) {
 	newA := cpu.a.read() ^ src.read()
 
 	cpu.a.write(newA)
@@ -472,7 +587,7 @@ func (cpu *CPU) xor(src R8Bit) {
 	cpu.cFlag.write(false)
 }
 
-func (cpu *CPU) and(src R8Bit) {
+func (cpu *cpu) and(src r8Bit) {
 	newVal := cpu.a.read() & src.read()
 	cpu.a.write(newVal)
 
@@ -482,19 +597,19 @@ func (cpu *CPU) and(src R8Bit) {
 	cpu.cFlag.write(false)
 }
 
-func (cpu *CPU) push(src R16Bit) {
+func (cpu *cpu) push(src r16Bit) {
 	cpu.mb.writeWord(cpu.sp.read()-2, src.read())
 	cpu.sp.dec(2)
 }
 
-func (cpu *CPU) pop(dst RW16Bit) {
+func (cpu *cpu) pop(dst rw16Bit) {
 	sp := cpu.mb.readWord(cpu.sp.read())
 	cpu.sp.inc(2)
 
 	dst.write(sp)
 }
 
-func (cpu *CPU) sub(src R8Bit) {
+func (cpu *cpu) sub(src r8Bit) {
 	oldAVal := cpu.a.read()
 	srcVal := src.read()
 
@@ -508,7 +623,7 @@ func (cpu *CPU) sub(src R8Bit) {
 	cpu.cFlag.write(srcVal > oldAVal)
 }
 
-func (cpu *CPU) sbc(src R8Bit) {
+func (cpu *cpu) sbc(src r8Bit) {
 	oldAVal := cpu.a.read()
 
 	cFlagVal := cpu.cFlag.readUint8()
@@ -526,14 +641,14 @@ func (cpu *CPU) sbc(src R8Bit) {
 	cpu.cFlag.write(newAVal >= oldAVal && (srcVal != 0 || cFlagVal != 0))
 }
 
-func (cpu *CPU) rst(src R16Bit) {
+func (cpu *cpu) rst(src r16Bit) {
 	cpu.mb.writeWord(cpu.sp.read()-2, cpu.pc.read())
 	cpu.sp.dec(2)
 
 	cpu.pc.write(src.read())
 }
 
-func (cpu *CPU) ret(cond bool) {
+func (cpu *cpu) ret(cond bool) {
 	if cond {
 		valAtSp := cpu.mb.readWord(cpu.sp.read())
 		cpu.pc.write(valAtSp)
@@ -542,34 +657,34 @@ func (cpu *CPU) ret(cond bool) {
 	}
 }
 
-func (cpu *CPU) call(src R16Bit) {
+func (cpu *cpu) call(src r16Bit) {
 	cpu.mb.writeWord(cpu.sp.read()-2, cpu.pc.read())
 	cpu.sp.dec(2)
 
 	cpu.pc.write(src.read())
 }
 
-func (cpu *CPU) bit(src R8Bit, bitN uint8) {
+func (cpu *cpu) bit(src r8Bit, bitN uint8) {
 	cpu.zFlag.write(!isBitSet8(src.read(), bitN))
 	cpu.nFlag.write(false)
 	cpu.hFlag.write(true)
 }
 
-func (cpu *CPU) res(src RW8Bit, bitN uint8) {
+func (cpu *cpu) res(src rw8Bit, bitN uint8) {
 	oldVal := src.read()
 	newVal := oldVal & (uint8(0xFF) - (1 << bitN))
 
 	src.write(newVal)
 }
 
-func (cpu *CPU) set(src RW8Bit, bitN uint8) {
+func (cpu *cpu) set(src rw8Bit, bitN uint8) {
 	oldVal := src.read()
 	newVal := oldVal | 1<<bitN
 
 	src.write(newVal)
 }
 
-func (cpu *CPU) executeOp(op *operation) uint8 {
+func (cpu *cpu) executeOp(op *operation) uint8 {
 	var cyclesOverride uint8
 
 	opcode := op.opcode
@@ -646,6 +761,7 @@ func (cpu *CPU) executeOp(op *operation) uint8 {
 
 	case 0x10:
 		assertSig("STOP 0")
+		cpu.stop()
 
 	case 0x11:
 		assertSig("LD DE d16")
@@ -1130,7 +1246,7 @@ func (cpu *CPU) executeOp(op *operation) uint8 {
 
 	case 0x76:
 		assertSig("HALT")
-		cpu.halted = true
+		cpu.halt()
 
 	case 0x77:
 		assertSig("LD (HL) A")
@@ -1477,7 +1593,7 @@ func (cpu *CPU) executeOp(op *operation) uint8 {
 
 	case 0xC7:
 		assertSig("RST 00H")
-		cpu.rst(AsValue16(0x00))
+		cpu.rst(asValue16(0x00))
 
 	case 0xC8:
 		assertSig("RET Z")
@@ -1531,7 +1647,7 @@ func (cpu *CPU) executeOp(op *operation) uint8 {
 
 	case 0xCF:
 		assertSig("RST 08H")
-		cpu.rst(AsValue16(0x08))
+		cpu.rst(asValue16(0x08))
 
 	case 0xD0:
 		assertSig("RET NC")
@@ -1582,7 +1698,7 @@ func (cpu *CPU) executeOp(op *operation) uint8 {
 
 	case 0xD7:
 		assertSig("RST 10H")
-		cpu.rst(AsValue16(0x10))
+		cpu.rst(asValue16(0x10))
 
 	case 0xD8:
 		assertSig("RET C")
@@ -1631,7 +1747,7 @@ func (cpu *CPU) executeOp(op *operation) uint8 {
 
 	case 0xDF:
 		assertSig("RST 18H")
-		cpu.rst(AsValue16(0x18))
+		cpu.rst(asValue16(0x18))
 
 	case 0xE0:
 		assertSig("LDH (a8) A")
@@ -1658,7 +1774,7 @@ func (cpu *CPU) executeOp(op *operation) uint8 {
 
 	case 0xE7:
 		assertSig("RST 20H")
-		cpu.rst(AsValue16(0x20))
+		cpu.rst(asValue16(0x20))
 
 	case 0xE8:
 		assertSig("ADD SP r8")
@@ -1697,7 +1813,7 @@ func (cpu *CPU) executeOp(op *operation) uint8 {
 
 	case 0xEF:
 		assertSig("RST 28H")
-		cpu.rst(AsValue16(0x28))
+		cpu.rst(asValue16(0x28))
 
 	case 0xF0:
 		assertSig("LDH A (a8)")
@@ -1725,7 +1841,7 @@ func (cpu *CPU) executeOp(op *operation) uint8 {
 
 	case 0xF7:
 		assertSig("RST 30H")
-		cpu.rst(AsValue16(0x30))
+		cpu.rst(asValue16(0x30))
 
 	case 0xF8:
 		assertSig("LD HL SP+r8")
@@ -1768,22 +1884,17 @@ func (cpu *CPU) executeOp(op *operation) uint8 {
 
 	case 0xFF:
 		assertSig("RST 38H")
-		cpu.rst(AsValue16(0x38))
+		cpu.rst(asValue16(0x38))
 
 	default:
-		panic(fmt.Sprintf("Opcode not implemented: %s", opcode.Addr))
+		cpu.illegalOpcode(op.pc, opcode.Addr)
+		return 4
 	}
 
-	if len(opcode.Cycles) == 1 {
-		return uint8(opcode.Cycles[0])
-	} else if cyclesOverride > 0 {
-		return cyclesOverride
-	} else {
-		panic(fmt.Sprintf("%v", opcode))
-	}
+	return cpu.opCycles(opcode, cyclesOverride)
 }
 
-func (cpu *CPU) executeCBOp(op *operation) uint8 {
+func (cpu *cpu) executeCBOp(op *operation) uint8 {
 	var cyclesOverride uint8
 
 	opcode := op.cbOpcode
@@ -2819,78 +2930,86 @@ func (cpu *CPU) executeCBOp(op *operation) uint8 {
 		cpu.set(cpu.a, 7)
 
 	default:
-		panic("This should never happen")
+		cpu.mb.fault(fmt.Errorf("%w: CB %s at %s", ErrIllegalOpcode, hex8(opcode.Addr), hex16(op.pc)))
+		return 0
 	}
 
+	return cpu.opCycles(opcode, cyclesOverride)
+}
+
+// opCycles is how long an instruction took. Conditional instructions have
+// two timings, and must say which one applied.
+func (cpu *cpu) opCycles(opcode *opcodeInfo, cyclesOverride uint8) uint8 {
 	if len(opcode.Cycles) == 1 {
 		return uint8(opcode.Cycles[0])
 	} else if cyclesOverride > 0 {
 		return cyclesOverride
-	} else {
-		panic(fmt.Sprintf("%v", opcode))
 	}
+
+	cpu.mb.fault(fmt.Errorf("No cycle count for opcode %s", hex8(opcode.Addr)))
+	return uint8(opcode.Cycles[0])
 }
 
-func (cpu *CPU) byteAt(addr uint16) *RAMByte {
-	return &RAMByte{mb: cpu.mb, offset: addr}
+func (cpu *cpu) byteAt(addr uint16) *ramByte {
+	return &ramByte{mb: cpu.mb, offset: addr}
 }
 
-func (cpu *CPU) wordAt(addr uint16) *RAMWord {
-	return &RAMWord{mb: cpu.mb, offset: addr}
+func (cpu *cpu) wordAt(addr uint16) *ramWord {
+	return &ramWord{mb: cpu.mb, offset: addr}
 }
 
-type Value8 struct {
+type value8 struct {
 	val uint8
 }
 
-func (rv Value8) read() uint8 {
+func (rv value8) read() uint8 {
 	return rv.val
 }
-func AsValue8(x uint8) *Value8 {
-	return &Value8{
+func asValue8(x uint8) *value8 {
+	return &value8{
 		val: x,
 	}
 }
 
-type Value16 struct {
+type value16 struct {
 	val uint16
 }
 
-func (rv Value16) read() uint16 {
+func (rv value16) read() uint16 {
 	return rv.val
 }
-func AsValue16(x uint16) *Value16 {
-	return &Value16{
+func asValue16(x uint16) *value16 {
+	return &value16{
 		val: x,
 	}
 }
 
 type operation struct {
-	opcode   *Opcode
-	cbOpcode *Opcode
+	opcode   *opcodeInfo
+	cbOpcode *opcodeInfo
 	pc       uint16
-	mb       *Motherboard
+	mb       *motherboard
 }
 
-func (o *operation) d8Val() *RAMByte {
+func (o *operation) d8Val() *ramByte {
 	offset := o.pc + uint16(o.opcode.Length-1)
-	return &RAMByte{mb: o.mb, offset: offset}
+	return &ramByte{mb: o.mb, offset: offset}
 }
-func (o *operation) d16Val() *RAMWord {
+func (o *operation) d16Val() *ramWord {
 	offset := o.pc + uint16(o.opcode.Length-2)
-	return &RAMWord{mb: o.mb, offset: offset}
+	return &ramWord{mb: o.mb, offset: offset}
 }
-func (o *operation) byteAtd8PlusFF00() *RAMByte {
+func (o *operation) byteAtd8PlusFF00() *ramByte {
 	d8 := o.d8Val().read()
-	return &RAMByte{mb: o.mb, offset: 0xFF00 + uint16(d8)}
+	return &ramByte{mb: o.mb, offset: 0xFF00 + uint16(d8)}
 }
-func (o *operation) byteAtd16() *RAMByte {
+func (o *operation) byteAtd16() *ramByte {
 	d16 := o.d16Val().read()
-	return &RAMByte{mb: o.mb, offset: d16}
+	return &ramByte{mb: o.mb, offset: d16}
 }
-func (o *operation) wordAtd16() *RAMWord {
+func (o *operation) wordAtd16() *ramWord {
 	d16 := o.d16Val().read()
-	return &RAMWord{mb: o.mb, offset: d16}
+	return &ramWord{mb: o.mb, offset: d16}
 }
 func (o operation) bytesConsumed() uint16 {
 	if o.cbOpcode != nil {
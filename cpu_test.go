@@ -1,4 +1,4 @@
-package main
+package gamebert
 
 import (
 	"bytes"
@@ -174,9 +174,16 @@ type lcdLcdcFlags struct {
 	Bgen *bool
 }
 
-func setupEnv(inp *TestInput) *Motherboard {
-	cart := NewCartridge("dr-mario.gb")
-	mb := NewMotherboard(cart)
+func setupEnv(t *testing.T, inp *TestInput) *motherboard {
+	bootROM, err := ioutil.ReadFile("bootrom.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cart, err := newCartridgeFromData(makeROM(0x8000, 0x00, 0x00, 0x00, "TEST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb := newMotherboard(cart, WithBootROM(bootROM))
 	cpu := mb.cpu
 
 	if inp.Cpu != nil {
@@ -330,10 +337,10 @@ func setupEnv(inp *TestInput) *Motherboard {
 
 	if inp.Ppu != nil {
 		for _, sb := range inp.Ppu.Vram {
-			mb.ppu.vRAM.write(*sb.Offset, *sb.Val)
+			mb.lcd.vRAM.write(*sb.Offset, *sb.Val)
 		}
 		for _, sb := range inp.Ppu.Oam {
-			mb.ppu.oam.write(*sb.Offset, *sb.Val)
+			mb.lcd.oam.write(*sb.Offset, *sb.Val)
 		}
 	}
 
@@ -436,7 +443,7 @@ func setupEnv(inp *TestInput) *Motherboard {
 	return mb
 }
 
-func assertTestOutput(t *testing.T, mb *Motherboard, out *TestOutput) {
+func assertTestOutput(t *testing.T, mb *motherboard, out *TestOutput) {
 	if out.Cpu != nil {
 		if out.Cpu.MasterInterruptsEnabled != nil {
 			assert.Equal(t, *out.Cpu.MasterInterruptsEnabled, mb.cpu.masterInterruptsEnabled, "cpu.masterInterruptsEnabled")
@@ -585,10 +592,10 @@ func assertTestOutput(t *testing.T, mb *Motherboard, out *TestOutput) {
 
 	if out.Ppu != nil {
 		for _, sb := range out.Ppu.Vram {
-			assert.Equal(t, *sb.Val, mb.ppu.vRAM.read(*sb.Offset))
+			assert.Equal(t, *sb.Val, mb.lcd.vRAM.read(*sb.Offset))
 		}
 		for _, sb := range out.Ppu.Oam {
-			assert.Equal(t, *sb.Val, mb.ppu.oam.read(*sb.Offset))
+			assert.Equal(t, *sb.Val, mb.lcd.oam.read(*sb.Offset))
 		}
 	}
 
@@ -690,6 +697,7 @@ func assertTestOutput(t *testing.T, mb *Motherboard, out *TestOutput) {
 }
 
 func TestSetupEnv(t *testing.T) {
+
 	files1, err := filepath.Glob("./tests/*.yaml")
 	if err != nil {
 		panic(err)
@@ -719,7 +727,7 @@ func TestSetupEnv(t *testing.T) {
 
 		for _, gt := range gamebertTests {
 			t.Run(fname+" "+gt.Name, func(t *testing.T) {
-				mb := setupEnv(gt.Input)
+				mb := setupEnv(t, gt.Input)
 				mb.tick()
 
 				assertTestOutput(t, mb, gt.Output)
@@ -0,0 +1,67 @@
+package gamebert
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors that stop the machine, or for ErrBreak pause it. The run methods
+// return them wrapped with more detail, so check for them with errors.Is,
+// or errors.As for the typed ones.
+var (
+	ErrBadROM          = errors.New("Bad ROM")
+	ErrIllegalOpcode   = errors.New("Illegal opcode")
+	ErrUnmappedAddress = errors.New("Unmapped address")
+	ErrBreak           = errors.New("Break")
+)
+
+// IllegalOpcodeError is an opcode that doesn't exist on the DMG. PC is left
+// pointing at it.
+type IllegalOpcodeError struct {
+	PC     uint16
+	Opcode uint8
+}
+
+func (e *IllegalOpcodeError) Error() string {
+	return fmt.Sprintf("Illegal opcode %s at %s", hex8(e.Opcode), hex16(e.PC))
+}
+
+func (e *IllegalOpcodeError) Is(target error) bool {
+	return target == ErrIllegalOpcode
+}
+
+// UnmappedAddressError is a read or write to memory that nothing answers
+type UnmappedAddressError struct {
+	Addr  uint16
+	Write bool
+}
+
+func (e *UnmappedAddressError) Error() string {
+	if e.Write {
+		return fmt.Sprintf("Write to unmapped address %s", hex16(e.Addr))
+	}
+	return fmt.Sprintf("Read from unmapped address %s", hex16(e.Addr))
+}
+
+func (e *UnmappedAddressError) Is(target error) bool {
+	return target == ErrUnmappedAddress
+}
+
+// BreakError pauses the machine for a debugger rather than stopping it.
+// Reason says why. The run methods return it once, and running on carries
+// on from where it was.
+type BreakError struct {
+	Reason error
+}
+
+func (e *BreakError) Error() string {
+	return fmt.Sprintf("Break: %v", e.Reason)
+}
+
+func (e *BreakError) Unwrap() error {
+	return e.Reason
+}
+
+func (e *BreakError) Is(target error) bool {
+	return target == ErrBreak
+}
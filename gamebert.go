@@ -1,20 +1,155 @@
-package main
+// Package gamebert emulates the original Game Boy (DMG). A Machine runs a
+// ROM an instruction or a frame at a time, and leaves drawing the screen,
+// playing the sound and reading the buttons to whatever is driving it.
+package gamebert
 
-import "github.com/faiface/pixel/pixelgl"
+import "io"
 
-type Gamebert struct {
-	mb *Motherboard
+// Machine is a Game Boy with a cartridge in it
+type Machine struct {
+	mb *motherboard
 }
 
-// Could probably do without the Gamebert struct
-func NewGamebert(cart Cartridge, win *pixelgl.Window) *Gamebert {
-	mb := NewMotherboard(cart, win)
+// New builds a Machine that runs rom, which must have a valid cartridge
+// header. See LoadROM for reading one from disk.
+func New(rom []byte, opts ...Option) (*Machine, error) {
+	cart, err := newCartridgeFromData(rom)
+	if err != nil {
+		return nil, err
+	}
+	return newMachine(cart, opts...), nil
+}
+
+func newMachine(cart cartridge, opts ...Option) *Machine {
+	return &Machine{
+		mb: newMotherboard(cart, opts...),
+	}
+}
+
+// The frontend runs at 60 frames a second, close enough to the LCD's 59.7
+const (
+	FramesPerSecond = 60
+	cyclesPerFrame  = cyclesPerSecond / FramesPerSecond
+)
+
+// The size of the screen, in pixels
+const (
+	ScreenWidth  = viewportCols
+	ScreenHeight = viewportRows
+)
+
+// StepInstruction runs a single instruction, or services an interrupt, and
+// returns how many cycles it took. If the machine has stopped, it returns
+// the error that stopped it instead, and likewise a BreakError.
+func (m *Machine) StepInstruction() (int, error) {
+	before := m.mb.cycles
+	m.mb.tick()
+	return int(m.mb.cycles - before), m.mb.runError()
+}
+
+// RunFrame runs the machine until it crosses into the next frame. If the
+// machine stops part way, see Err, it returns early with the error. It also
+// returns early with a BreakError, and the next call carries on from there.
+func (m *Machine) RunFrame() error {
+	for {
+		lastCycles := m.mb.cycles
+		m.mb.tick()
+		if err := m.mb.runError(); err != nil {
+			return err
+		}
+		if m.mb.cycles%cyclesPerFrame < lastCycles%cyclesPerFrame {
+			return nil
+		}
+	}
+}
+
+// RunFrames runs n frames as fast as it can, for when there's no frontend
+// to keep time
+func (m *Machine) RunFrames(n int) error {
+	for i := 0; i < n; i++ {
+		if err := m.RunFrame(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Err is the error that stopped the machine, such as an IllegalOpcodeError,
+// or nil if it's running. Once stopped, it stays stopped, with everything
+// left as it was for Peek and Framebuffer to look at, until a state is
+// loaded.
+func (m *Machine) Err() error {
+	return m.mb.err
+}
+
+// LockedUp is whether the CPU has hung on an illegal opcode, as it does
+// with IllegalOpcodeLockUp or IllegalOpcodeBreak. The rest of the machine
+// keeps running.
+func (m *Machine) LockedUp() bool {
+	return m.mb.cpu.lockedUp
+}
+
+// Cycles is how many cycles the machine has run for
+func (m *Machine) Cycles() uint64 {
+	return m.mb.cycles
+}
+
+// Framebuffer returns a copy of the most recently drawn frame, row by row,
+// with a shade from 0 (lightest) to 3 (darkest) for each pixel
+func (m *Machine) Framebuffer() []uint8 {
+	fb := make([]uint8, ScreenWidth*ScreenHeight)
+	copy(fb, m.mb.lcd.renderer.screenBuffer.data)
+	return fb
+}
 
-	return &Gamebert{
-		mb: mb,
+// Buttons is a set of held buttons, with a bit for each Button. It can be
+// used as an Input.
+type Buttons uint8
+
+// NewButtons returns the set of the given buttons
+func NewButtons(held ...Button) Buttons {
+	var b Buttons
+	for _, btn := range held {
+		b |= 1 << btn
 	}
+	return b
+}
+
+// Pressed makes Buttons an Input
+func (b Buttons) Pressed(btn Button) bool {
+	return b&(1<<btn) != 0
+}
+
+// SetButtons holds down exactly the buttons in b, until the next call. It
+// replaces any Input the machine was built with.
+func (m *Machine) SetButtons(b Buttons) {
+	m.mb.joypadIO.input = b
+}
+
+// Peek reads a byte from the memory map, as the CPU would, but never stops
+// the machine
+func (m *Machine) Peek(addr uint16) uint8 {
+	return m.mb.peekByte(addr)
+}
+
+// Poke writes a byte to the memory map, as the CPU would, side effects and
+// all
+func (m *Machine) Poke(addr uint16, val uint8) {
+	m.mb.writeByte(addr, val)
+}
+
+// SaveState writes a snapshot of the whole machine to w
+func (m *Machine) SaveState(w io.Writer) error {
+	return m.mb.saveState(w)
+}
+
+// LoadState restores a snapshot written by SaveState, which also gets a
+// stopped machine going again. If it fails, the machine is left as it was.
+func (m *Machine) LoadState(r io.Reader) error {
+	return m.mb.loadState(r)
 }
 
-func (gb *Gamebert) tick() {
-	gb.mb.tick()
+// APU gives access to the sound, for playing or recording it
+func (m *Machine) APU() *APU {
+	return m.mb.apu
 }
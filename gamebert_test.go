@@ -0,0 +1,205 @@
+package gamebert
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeInput holds down a fixed set of buttons
+type fakeInput map[Button]bool
+
+func (in fakeInput) Pressed(b Button) bool {
+	return in[b]
+}
+
+func TestHeadlessFrames(t *testing.T) {
+	rom := makeROM(0x8000, 0x00, 0x00, 0x00, "HEADLESS")
+	// Fill tile 0, which the whole background map points at, with the
+	// darkest shade, then spin
+	copy(rom[0x0100:], []byte{
+		0x21, 0x00, 0x80, // LD HL,8000
+		0x3E, 0xFF, // LD A,FF
+		0x22,       // LD (HL+),A
+		0x7D,       // LD A,L
+		0xFE, 0x10, // CP 10
+		0x20, 0xF8, // JR NZ,-8
+		0x18, 0xFE, // JR -2
+	})
+	m, err := New(rom)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(0), m.Framebuffer()[72*ScreenWidth+80])
+
+	assert.NoError(t, m.RunFrames(2))
+	assert.GreaterOrEqual(t, m.Cycles(), uint64(2*cyclesPerFrame))
+	fb := m.Framebuffer()
+	assert.Len(t, fb, ScreenWidth*ScreenHeight)
+	for _, shade := range fb {
+		if !assert.Equal(t, uint8(3), shade) {
+			break
+		}
+	}
+}
+
+func TestStepInstruction(t *testing.T) {
+	rom := makeROM(0x8000, 0x00, 0x00, 0x00, "STEP")
+	copy(rom[0x0100:], []byte{
+		0x00,             // NOP
+		0x21, 0x00, 0xC0, // LD HL,C000
+		0x36, 0x42, // LD (HL),42
+	})
+	m, err := New(rom)
+	assert.NoError(t, err)
+
+	for _, want := range []int{4, 12, 12} {
+		cycles, err := m.StepInstruction()
+		assert.NoError(t, err)
+		assert.Equal(t, want, cycles)
+	}
+	assert.Equal(t, uint8(0x42), m.Peek(0xC000))
+
+	m.Poke(0xC001, 0x99)
+	assert.Equal(t, uint8(0x99), m.Peek(0xC001))
+
+	_, err = New([]byte("too short"))
+	assert.ErrorIs(t, err, ErrBadROM)
+}
+
+func TestIllegalOpcodeStopsMachine(t *testing.T) {
+	rom := makeROM(0x8000, 0x00, 0x00, 0x00, "ILLEGAL")
+	copy(rom[0x0100:], []byte{
+		0x3E, 0x42, // LD A,42
+		0xD3, // illegal
+	})
+	m, err := New(rom)
+	assert.NoError(t, err)
+
+	var state bytes.Buffer
+	assert.NoError(t, m.SaveState(&state))
+
+	err = m.RunFrame()
+	assert.ErrorIs(t, err, ErrIllegalOpcode)
+	var illegal *IllegalOpcodeError
+	if assert.ErrorAs(t, err, &illegal) {
+		assert.Equal(t, uint16(0x0102), illegal.PC)
+		assert.Equal(t, uint8(0xD3), illegal.Opcode)
+	}
+	assert.Equal(t, err, m.Err())
+
+	// It stays stopped where it was, for a look around
+	cycles := m.Cycles()
+	_, err = m.StepInstruction()
+	assert.ErrorIs(t, err, ErrIllegalOpcode)
+	assert.Equal(t, cycles, m.Cycles())
+	assert.Equal(t, uint16(0x0102), m.mb.cpu.pc.read())
+	assert.Equal(t, uint8(0x42), m.mb.cpu.a.read())
+	assert.Equal(t, uint8(0xD3), m.Peek(0x0102))
+
+	// Loading a state starts it again
+	assert.NoError(t, m.LoadState(&state))
+	assert.NoError(t, m.Err())
+	_, err = m.StepInstruction()
+	assert.NoError(t, err)
+}
+
+func TestJoypadInput(t *testing.T) {
+	cart, err := newCartridgeFromData(makeROM(0x8000, 0x00, 0x00, 0x00, "JOYPAD"))
+	assert.NoError(t, err)
+
+	mb := newMotherboard(cart, WithInput(fakeInput{ButtonRight: true, ButtonStart: true}))
+
+	// Directions
+	mb.writeByte(0xFF00, 0x20)
+	assert.Equal(t, uint8(0b1110), mb.readByte(0xFF00)&0x0F)
+
+	// Buttons
+	mb.writeByte(0xFF00, 0x10)
+	assert.Equal(t, uint8(0b0111), mb.readByte(0xFF00)&0x0F)
+
+	// Nothing is pressed without an input
+	m := newMachine(cart)
+	m.Poke(0xFF00, 0x10)
+	assert.Equal(t, uint8(0b1111), m.Peek(0xFF00)&0x0F)
+
+	m.SetButtons(NewButtons(ButtonA, ButtonB))
+	assert.Equal(t, uint8(0b1100), m.Peek(0xFF00)&0x0F)
+	m.SetButtons(0)
+	assert.Equal(t, uint8(0b1111), m.Peek(0xFF00)&0x0F)
+}
+
+func newIllegalOpcodeMachine(t *testing.T, policy IllegalOpcodePolicy) *Machine {
+	rom := makeROM(0x8000, 0x00, 0x00, 0x00, "LOCKUP")
+	copy(rom[0x0100:], []byte{
+		0x3E, 0x04, // LD A,04
+		0xE0, 0xFF, // LDH (FF),A - enable the timer interrupt
+		0xFB, // EI
+		0xED, // illegal
+	})
+	// Anything reaching the timer handler would spin there
+	copy(rom[0x0050:], []byte{0x18, 0xFE})
+
+	m, err := New(rom, WithIllegalOpcodePolicy(policy))
+	assert.NoError(t, err)
+	return m
+}
+
+func TestIllegalOpcodeLockUp(t *testing.T) {
+	m := newIllegalOpcodeMachine(t, IllegalOpcodeLockUp)
+
+	assert.NoError(t, m.RunFrame())
+	assert.True(t, m.LockedUp())
+	assert.NoError(t, m.Err())
+
+	// The CPU stays put, even with an interrupt waiting...
+	m.Poke(0xFF0F, 0x04)
+	assert.NoError(t, m.RunFrames(2))
+	assert.Equal(t, uint16(0x0105), m.mb.cpu.pc.read())
+
+	// ...while the rest of the machine carries on
+	ly := m.Peek(0xFF44)
+	for i := 0; i < 200; i++ {
+		cycles, err := m.StepInstruction()
+		assert.NoError(t, err)
+		assert.Equal(t, 4, cycles)
+	}
+	assert.NotEqual(t, ly, m.Peek(0xFF44))
+}
+
+func TestIllegalOpcodeBreak(t *testing.T) {
+	m := newIllegalOpcodeMachine(t, IllegalOpcodeBreak)
+
+	err := m.RunFrame()
+	assert.ErrorIs(t, err, ErrBreak)
+	assert.ErrorIs(t, err, ErrIllegalOpcode)
+	var illegal *IllegalOpcodeError
+	if assert.ErrorAs(t, err, &illegal) {
+		assert.Equal(t, uint16(0x0105), illegal.PC)
+		assert.Equal(t, uint8(0xED), illegal.Opcode)
+	}
+	assert.NoError(t, m.Err())
+	assert.True(t, m.LockedUp())
+
+	// Carrying on leaves it locked up, like the hardware
+	assert.NoError(t, m.RunFrame())
+	assert.True(t, m.LockedUp())
+	assert.Equal(t, uint16(0x0105), m.mb.cpu.pc.read())
+}
+
+func TestWithSampleRate(t *testing.T) {
+	rom := makeROM(0x8000, 0x00, 0x00, 0x00, "RATE")
+	copy(rom[0x0100:], []byte{0x18, 0xFE}) // JR -2
+
+	for _, rate := range []int{DefaultSampleRate, 22050} {
+		opts := []Option{}
+		if rate != DefaultSampleRate {
+			opts = append(opts, WithSampleRate(rate))
+		}
+		m, err := New(rom, opts...)
+		assert.NoError(t, err)
+
+		// A second's worth of stereo samples
+		assert.NoError(t, m.RunFrames(FramesPerSecond))
+		assert.InDelta(t, 2*rate, len(m.APU().TakeSamples()), 8, "%dHz", rate)
+	}
+}
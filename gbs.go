@@ -0,0 +1,289 @@
+package gamebert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// GBS files are sound rips: a game's music driver and data, plus a header
+// saying how to start each song and how often to call the driver.
+// https://ocremix.org/info/GBS_Format_Specification
+
+const gbsHeaderSize = 0x70
+
+type GBSHeader struct {
+	Version   uint8
+	NumSongs  uint8
+	FirstSong uint8 // 1-based
+
+	LoadAddr     uint16
+	InitAddr     uint16
+	PlayAddr     uint16
+	StackPointer uint16
+
+	// If TAC enables the timer, play is called on each timer interrupt.
+	// Otherwise it's called at vblank.
+	TMA uint8
+	TAC uint8
+
+	Title     string
+	Author    string
+	Copyright string
+}
+
+func IsGBS(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("GBS"))
+}
+
+// ParseGBS returns the header and the code that gets loaded at LoadAddr
+func ParseGBS(data []byte) (*GBSHeader, []byte, error) {
+	if !IsGBS(data) {
+		return nil, nil, errors.New("Not a GBS file")
+	}
+	if len(data) < gbsHeaderSize {
+		return nil, nil, fmt.Errorf("GBS file too small to contain a header: %d bytes", len(data))
+	}
+
+	str := func(b []byte) string {
+		return strings.TrimRight(string(b), "\x00 ")
+	}
+	h := &GBSHeader{
+		Version:      data[0x03],
+		NumSongs:     data[0x04],
+		FirstSong:    data[0x05],
+		LoadAddr:     binary.LittleEndian.Uint16(data[0x06:]),
+		InitAddr:     binary.LittleEndian.Uint16(data[0x08:]),
+		PlayAddr:     binary.LittleEndian.Uint16(data[0x0A:]),
+		StackPointer: binary.LittleEndian.Uint16(data[0x0C:]),
+		TMA:          data[0x0E],
+		TAC:          data[0x0F],
+		Title:        str(data[0x10:0x30]),
+		Author:       str(data[0x30:0x50]),
+		Copyright:    str(data[0x50:0x70]),
+	}
+
+	if h.Version != 1 {
+		return nil, nil, fmt.Errorf("Unsupported GBS version: %d", h.Version)
+	}
+	if h.NumSongs == 0 {
+		return nil, nil, errors.New("GBS file has no songs")
+	}
+	if h.FirstSong == 0 || h.FirstSong > h.NumSongs {
+		h.FirstSong = 1
+	}
+	// Below 0x400 the code would overlap the vectors we set up
+	if h.LoadAddr < 0x0400 || h.LoadAddr >= 0x8000 {
+		return nil, nil, fmt.Errorf("GBS load address out of range: %04X", h.LoadAddr)
+	}
+
+	return h, data[gbsHeaderSize:], nil
+}
+
+func (h *GBSHeader) UsesTimer() bool {
+	return isBitSet8(h.TAC, 2)
+}
+
+// When a routine returns here, we know it has finished. It holds an
+// infinite loop for the CPU to spin in until the next call.
+const gbsIdleAddr = 0x0070
+
+// gbsROM builds a ROM image with the code at its load address. RST
+// instructions in GBS code jump relative to the load address, so each RST
+// vector is pointed there. Interrupts are never enabled by us, but return
+// straight away in case the code enables them itself.
+func gbsROM(h *GBSHeader, code []byte) []byte {
+	size := int(h.LoadAddr) + len(code)
+	// Round up to a whole number of banks
+	size = (size + 0x3FFF) &^ 0x3FFF
+	if size < 0x8000 {
+		size = 0x8000
+	}
+
+	rom := make([]byte, size)
+	copy(rom[h.LoadAddr:], code)
+
+	for vec := uint16(0); vec < 0x40; vec += 8 {
+		rom[vec] = 0xC3 // JP a16
+		binary.LittleEndian.PutUint16(rom[vec+1:], h.LoadAddr+vec)
+	}
+	for vec := 0x40; vec <= 0x60; vec += 8 {
+		rom[vec] = 0xD9 // RETI
+	}
+	rom[gbsIdleAddr] = 0x18   // JR r8
+	rom[gbsIdleAddr+1] = 0xFE // -2
+
+	return rom
+}
+
+// gbsCartridge maps a GBS ROM image like an MBC1, with RAM always enabled
+type gbsCartridge struct {
+	rom  []byte
+	bank int
+	ram  [0x2000]uint8
+}
+
+func (c *gbsCartridge) read(loc uint16) uint8 {
+	if loc < 0x4000 {
+		return c.rom[loc]
+	} else if loc < 0x8000 {
+		addr := c.bank*0x4000 + int(loc-0x4000)
+		if addr >= len(c.rom) {
+			return 0xFF
+		}
+		return c.rom[addr]
+	} else if loc >= 0xA000 && loc < 0xC000 {
+		return c.ram[loc-0xA000]
+	}
+	return 0xFF
+}
+
+func (c *gbsCartridge) write(loc uint16, val uint8) {
+	if loc >= 0x2000 && loc < 0x4000 {
+		c.bank = int(val)
+		if c.bank == 0 {
+			c.bank = 1
+		}
+	} else if loc >= 0xA000 && loc < 0xC000 {
+		c.ram[loc-0xA000] = val
+	}
+}
+
+// How often play is called when it's driven by vblank
+const gbsVBlankCycles = 70224
+
+// GBSPlayer runs a GBS file's driver on the CPU, timer and APU, with the
+// LCD switched off.
+type GBSPlayer struct {
+	header *GBSHeader
+	rom    []byte
+	mb     *motherboard
+
+	song       int // 0-based
+	nextVBlank uint64
+
+	opts []Option
+}
+
+// NewGBSPlayer loads a GBS file and starts its first song. opts configure
+// the machine it plays on, such as WithSampleRate.
+func NewGBSPlayer(data []byte, opts ...Option) (*GBSPlayer, error) {
+	header, code, err := ParseGBS(data)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &GBSPlayer{
+		header: header,
+		rom:    gbsROM(header, code),
+		opts:   opts,
+	}
+	p.StartSong(int(header.FirstSong) - 1)
+	return p, nil
+}
+
+// StartSong resets the machine and calls init for a song, numbered from 0
+func (p *GBSPlayer) StartSong(song int) {
+	p.song = song
+
+	cart := &gbsCartridge{rom: p.rom, bank: 1}
+	mb := newMotherboard(cart, p.opts...)
+	if p.mb != nil {
+		// Keep the APU, along with whatever is listening to it
+		mb.apu = p.mb.apu
+	}
+	p.mb = mb
+
+	mb.writeByte(0xFF40, 0x00) // LCD off
+	mb.writeByte(0xFFFF, 0x00) // no interrupts
+	mb.writeByte(0xFF06, p.header.TMA)
+	mb.writeByte(0xFF07, p.header.TAC&0b111)
+
+	// Power cycle the APU to clear out the last song
+	mb.writeByte(0xFF26, 0x00)
+	mb.writeByte(0xFF26, 0x80)
+	mb.writeByte(0xFF25, 0xFF)
+	mb.writeByte(0xFF24, 0x77)
+
+	mb.cpu.sp.write(p.header.StackPointer)
+	mb.cpu.a.write(uint8(song))
+	p.call(p.header.InitAddr)
+
+	p.nextVBlank = gbsVBlankCycles
+}
+
+// call pushes the idle address and jumps to addr, as if it had been CALLed
+// from the idle loop.
+func (p *GBSPlayer) call(addr uint16) {
+	cpu := p.mb.cpu
+	cpu.sp.dec(2)
+	p.mb.writeWord(cpu.sp.read(), gbsIdleAddr)
+	cpu.pc.write(addr)
+}
+
+func (p *GBSPlayer) tick() {
+	p.mb.tick()
+
+	// Play requests are held until the current routine returns
+	playDue := false
+	if p.header.UsesTimer() {
+		playDue = p.mb.cpu.intTriggeredTimer.read()
+	} else {
+		playDue = p.mb.cycles >= p.nextVBlank
+	}
+
+	if playDue && p.mb.cpu.pc.read() == gbsIdleAddr {
+		if p.header.UsesTimer() {
+			p.mb.cpu.intTriggeredTimer.write(false)
+		} else {
+			p.nextVBlank += gbsVBlankCycles
+		}
+		p.call(p.header.PlayAddr)
+	}
+}
+
+// RunFrame runs a frame's worth of cycles. It returns an error if the
+// driver stops the machine. Changing track starts it afresh.
+func (p *GBSPlayer) RunFrame() error {
+	// Changing track replaces the motherboard, so count cycles here
+	// rather than relying on mb.cycles
+	for cycles := uint64(0); cycles < cyclesPerFrame; {
+		before := p.mb.cycles
+		p.tick()
+		if err := p.mb.runError(); err != nil {
+			return err
+		}
+		cycles += p.mb.cycles - before
+	}
+	return nil
+}
+
+// Header is the GBS file's header
+func (p *GBSPlayer) Header() *GBSHeader {
+	return p.header
+}
+
+// APU gives access to the sound. It stays the same across tracks.
+func (p *GBSPlayer) APU() *APU {
+	return p.mb.apu
+}
+
+func (p *GBSPlayer) NextSong() {
+	p.StartSong((p.song + 1) % int(p.header.NumSongs))
+}
+
+func (p *GBSPlayer) PrevSong() {
+	n := int(p.header.NumSongs)
+	p.StartSong((p.song + n - 1) % n)
+}
+
+// Title describes what's playing, for the window title
+func (p *GBSPlayer) Title() string {
+	title := p.header.Title
+	if title == "" {
+		title = "GBS"
+	}
+	return fmt.Sprintf("%s - track %d/%d", title, p.song+1, p.header.NumSongs)
+}
@@ -0,0 +1,121 @@
+package gamebert
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// makeGBS builds a GBS file loaded at 0x0400 with a driver that:
+//
+//	init: stores the song number at C000 and calls RST 08
+//	RST 08: stores 0x42 at C002
+//	play: increments C001
+func makeGBS(numSongs, firstSong, tma, tac uint8) []byte {
+	data := make([]byte, gbsHeaderSize+0x30)
+	copy(data, "GBS")
+	data[0x03] = 1
+	data[0x04] = numSongs
+	data[0x05] = firstSong
+	binary.LittleEndian.PutUint16(data[0x06:], 0x0400)
+	binary.LittleEndian.PutUint16(data[0x08:], 0x0410)
+	binary.LittleEndian.PutUint16(data[0x0A:], 0x0420)
+	binary.LittleEndian.PutUint16(data[0x0C:], 0xDFFF)
+	data[0x0E] = tma
+	data[0x0F] = tac
+	copy(data[0x10:], "Test Tune")
+	copy(data[0x30:], "Robert")
+
+	code := data[gbsHeaderSize:]
+	copy(code[0x08:], []byte{0x3E, 0x42, 0xEA, 0x02, 0xC0, 0xC9})
+	copy(code[0x10:], []byte{0xEA, 0x00, 0xC0, 0xCF, 0xC9})
+	copy(code[0x20:], []byte{0xFA, 0x01, 0xC0, 0x3C, 0xEA, 0x01, 0xC0, 0xC9})
+	return data
+}
+
+func TestParseGBS(t *testing.T) {
+	h, code, err := ParseGBS(makeGBS(5, 2, 0, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "Test Tune", h.Title)
+	assert.Equal(t, "Robert", h.Author)
+	assert.Equal(t, uint8(5), h.NumSongs)
+	assert.Equal(t, uint8(2), h.FirstSong)
+	assert.Equal(t, uint16(0x0420), h.PlayAddr)
+	assert.Equal(t, 0x30, len(code))
+	assert.False(t, h.UsesTimer())
+
+	_, _, err = ParseGBS([]byte("GBS\x01"))
+	assert.NotNil(t, err)
+
+	_, _, err = ParseGBS(makeGBS(0, 1, 0, 0))
+	assert.NotNil(t, err)
+
+	data := makeGBS(1, 1, 0, 0)
+	binary.LittleEndian.PutUint16(data[0x06:], 0x0100)
+	_, _, err = ParseGBS(data)
+	assert.NotNil(t, err)
+}
+
+func runGBSCycles(p *GBSPlayer, cycles uint64) {
+	start := p.mb.cycles
+	for p.mb.cycles-start < cycles {
+		p.tick()
+	}
+}
+
+func TestGBSPlayerVBlank(t *testing.T) {
+	p, err := NewGBSPlayer(makeGBS(3, 2, 0, 0))
+	assert.Nil(t, err)
+	runGBSCycles(p, 10*gbsVBlankCycles+100)
+
+	assert.Equal(t, uint8(1), p.mb.readByte(0xC000))
+	// RST 08 went to the load address + 8
+	assert.Equal(t, uint8(0x42), p.mb.readByte(0xC002))
+	assert.Equal(t, uint8(10), p.mb.readByte(0xC001))
+	assert.Equal(t, uint16(gbsIdleAddr), p.mb.cpu.pc.read())
+	assert.False(t, p.mb.lcd.flagLcdEnabled.read())
+
+	// Changing track starts afresh
+	apu := p.mb.apu
+	p.NextSong()
+	assert.Equal(t, "Test Tune - track 3/3", p.Title())
+	runGBSCycles(p, 100)
+	assert.Equal(t, uint8(2), p.mb.readByte(0xC000))
+	assert.Equal(t, uint8(0), p.mb.readByte(0xC001))
+	assert.Same(t, apu, p.mb.apu)
+
+	p.NextSong()
+	assert.Equal(t, 0, p.song)
+	p.PrevSong()
+	assert.Equal(t, 2, p.song)
+}
+
+func TestGBSPlayerTimer(t *testing.T) {
+	// 4096Hz with a modulo of 0xC0 overflows every 64 * 1024 cycles
+	p, err := NewGBSPlayer(makeGBS(1, 1, 0xC0, 0x04))
+	assert.Nil(t, err)
+	assert.True(t, p.header.UsesTimer())
+
+	// The first overflow counts up from 0 rather than TMA
+	runGBSCycles(p, 256*1024+4*64*1024+100)
+	assert.Equal(t, uint8(5), p.mb.readByte(0xC001))
+}
+
+func TestGBSCartridgeBanking(t *testing.T) {
+	rom := make([]byte, 0x10000)
+	rom[0x4000] = 1
+	rom[0xC000] = 3
+	cart := &gbsCartridge{rom: rom, bank: 1}
+
+	assert.Equal(t, uint8(1), cart.read(0x4000))
+	cart.write(0x2000, 3)
+	assert.Equal(t, uint8(3), cart.read(0x4000))
+	cart.write(0x2000, 0)
+	assert.Equal(t, uint8(1), cart.read(0x4000))
+	cart.write(0x2000, 9)
+	assert.Equal(t, uint8(0xFF), cart.read(0x4000))
+
+	cart.write(0xA123, 0x55)
+	assert.Equal(t, uint8(0x55), cart.read(0xA123))
+}
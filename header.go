@@ -0,0 +1,244 @@
+package gamebert
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	headerStart = 0x0100
+	headerEnd   = 0x0150
+)
+
+// CartridgeHeader is the parsed form of the cartridge header at
+// 0x0100-0x014F.
+// https://gbdev.io/pandocs/The_Cartridge_Header.html
+type CartridgeHeader struct {
+	Title           string
+	ManufacturerNew string
+	CGBFlag         uint8 // 0x0143
+	NewLicensee     string
+	SGBFlag         uint8 // 0x0146
+	CartridgeType   uint8 // 0x0147
+	ROMSizeCode     uint8 // 0x0148
+	RAMSizeCode     uint8 // 0x0149
+	Destination     uint8 // 0x014A
+	OldLicensee     uint8 // 0x014B
+	Version         uint8 // 0x014C
+	HeaderChecksum  uint8 // 0x014D
+	GlobalChecksum  uint16
+
+	// Decoded sizes in bytes
+	ROMSize int
+	RAMSize int
+}
+
+func ParseCartridgeHeader(data []byte) (*CartridgeHeader, error) {
+	if len(data) < headerEnd {
+		return nil, fmt.Errorf("ROM too small to contain a header: %d bytes", len(data))
+	}
+
+	h := &CartridgeHeader{
+		CGBFlag:        data[0x0143],
+		NewLicensee:    strings.TrimRight(string(data[0x0144:0x0146]), "\x00"),
+		SGBFlag:        data[0x0146],
+		CartridgeType:  data[0x0147],
+		ROMSizeCode:    data[0x0148],
+		RAMSizeCode:    data[0x0149],
+		Destination:    data[0x014A],
+		OldLicensee:    data[0x014B],
+		Version:        data[0x014C],
+		HeaderChecksum: data[0x014D],
+		GlobalChecksum: combine8(data[0x014E], data[0x014F]),
+	}
+
+	// Later cartridges shrank the title to make room for the CGB flag, and
+	// later still for a manufacturer code.
+	titleEnd := 0x0144
+	if h.CGBFlag&0x80 != 0 {
+		titleEnd = 0x0143
+		if isUpperAlnum(data[0x013F:0x0143]) && data[0x013E] == 0x00 {
+			titleEnd = 0x013F
+			h.ManufacturerNew = string(data[0x013F:0x0143])
+		}
+	}
+	h.Title = strings.TrimRight(string(data[0x0134:titleEnd]), "\x00 ")
+
+	if h.ROMSizeCode > 0x08 {
+		return nil, fmt.Errorf("Unknown ROM size code: %02x", h.ROMSizeCode)
+	}
+	h.ROMSize = 0x8000 << h.ROMSizeCode
+
+	ramSize, ok := ramSizes[h.RAMSizeCode]
+	if !ok {
+		return nil, fmt.Errorf("Unknown RAM size code: %02x", h.RAMSizeCode)
+	}
+	h.RAMSize = ramSize
+
+	return h, nil
+}
+
+var ramSizes = map[uint8]int{
+	0x00: 0,
+	0x01: 0x800,
+	0x02: 0x2000,
+	0x03: 0x8000,
+	0x04: 0x20000,
+	0x05: 0x10000,
+}
+
+func isUpperAlnum(b []byte) bool {
+	for _, c := range b {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+type mbcKind int
+
+const (
+	mbcUnknown mbcKind = iota
+	mbcNone
+	mbcKind1
+	mbcKind2
+	mbcKind3
+	mbcKind5
+)
+
+type cartridgeType struct {
+	name    string
+	mbc     mbcKind
+	ram     bool
+	battery bool
+	rtc     bool
+	rumble  bool
+}
+
+// https://gbdev.io/pandocs/The_Cartridge_Header.html#0147--cartridge-type
+var cartridgeTypes = map[uint8]cartridgeType{
+	0x00: {name: "ROM ONLY", mbc: mbcNone},
+	0x01: {name: "MBC1", mbc: mbcKind1},
+	0x02: {name: "MBC1+RAM", mbc: mbcKind1, ram: true},
+	0x03: {name: "MBC1+RAM+BATTERY", mbc: mbcKind1, ram: true, battery: true},
+	0x05: {name: "MBC2", mbc: mbcKind2},
+	0x06: {name: "MBC2+BATTERY", mbc: mbcKind2, battery: true},
+	0x08: {name: "ROM+RAM", mbc: mbcNone, ram: true},
+	0x09: {name: "ROM+RAM+BATTERY", mbc: mbcNone, ram: true, battery: true},
+	0x0F: {name: "MBC3+TIMER+BATTERY", mbc: mbcKind3, battery: true, rtc: true},
+	0x10: {name: "MBC3+TIMER+RAM+BATTERY", mbc: mbcKind3, ram: true, battery: true, rtc: true},
+	0x11: {name: "MBC3", mbc: mbcKind3},
+	0x12: {name: "MBC3+RAM", mbc: mbcKind3, ram: true},
+	0x13: {name: "MBC3+RAM+BATTERY", mbc: mbcKind3, ram: true, battery: true},
+	0x19: {name: "MBC5", mbc: mbcKind5},
+	0x1A: {name: "MBC5+RAM", mbc: mbcKind5, ram: true},
+	0x1B: {name: "MBC5+RAM+BATTERY", mbc: mbcKind5, ram: true, battery: true},
+	0x1C: {name: "MBC5+RUMBLE", mbc: mbcKind5, rumble: true},
+	0x1D: {name: "MBC5+RUMBLE+RAM", mbc: mbcKind5, ram: true, rumble: true},
+	0x1E: {name: "MBC5+RUMBLE+RAM+BATTERY", mbc: mbcKind5, ram: true, battery: true, rumble: true},
+}
+
+func (h *CartridgeHeader) cartType() cartridgeType {
+	ct, ok := cartridgeTypes[h.CartridgeType]
+	if !ok {
+		return cartridgeType{name: fmt.Sprintf("UNKNOWN (%02x)", h.CartridgeType), mbc: mbcUnknown}
+	}
+	return ct
+}
+
+func (h *CartridgeHeader) TypeName() string {
+	return h.cartType().name
+}
+
+func (h *CartridgeHeader) HasBattery() bool {
+	return h.cartType().battery
+}
+
+func (h *CartridgeHeader) HasRTC() bool {
+	return h.cartType().rtc
+}
+
+func (h *CartridgeHeader) HasRumble() bool {
+	return h.cartType().rumble
+}
+
+func (h *CartridgeHeader) IsCGB() bool {
+	return h.CGBFlag&0x80 != 0
+}
+
+func (h *CartridgeHeader) IsCGBOnly() bool {
+	return h.CGBFlag == 0xC0
+}
+
+func (h *CartridgeHeader) IsSGB() bool {
+	return h.SGBFlag == 0x03
+}
+
+// Licensee returns the publisher code, preferring the new two-character
+// code when the old code tells us to look there.
+func (h *CartridgeHeader) Licensee() string {
+	if h.OldLicensee == 0x33 {
+		return h.NewLicensee
+	}
+	return hex8(h.OldLicensee)
+}
+
+// The Nintendo logo at 0x0104-0x0133, which the boot ROM checks before
+// starting the game.
+var nintendoLogo = []byte{
+	0xCE, 0xED, 0x66, 0x66, 0xCC, 0x0D, 0x00, 0x0B, 0x03, 0x73, 0x00, 0x83,
+	0x00, 0x0C, 0x00, 0x0D, 0x00, 0x08, 0x11, 0x1F, 0x88, 0x89, 0x00, 0x0E,
+	0xDC, 0xCC, 0x6E, 0xE6, 0xDD, 0xDD, 0xD9, 0x99, 0xBB, 0xBB, 0x67, 0x63,
+	0x6E, 0x0E, 0xEC, 0xCC, 0xDD, 0xDC, 0x99, 0x9F, 0xBB, 0xB9, 0x33, 0x3E,
+}
+
+// https://gbdev.io/pandocs/The_Cartridge_Header.html#014d--header-checksum
+func ComputeHeaderChecksum(data []byte) uint8 {
+	x := uint8(0)
+	for _, b := range data[0x0134:0x014D] {
+		x = x - b - 1
+	}
+	return x
+}
+
+// https://gbdev.io/pandocs/The_Cartridge_Header.html#014e-014f--global-checksum
+func ComputeGlobalChecksum(data []byte) uint16 {
+	x := uint16(0)
+	for i, b := range data {
+		if i == 0x014E || i == 0x014F {
+			continue
+		}
+		x += uint16(b)
+	}
+	return x
+}
+
+func LogoValid(data []byte) bool {
+	return bytes.Equal(data[0x0104:0x0134], nintendoLogo)
+}
+
+// ValidateROM checks the logo and both checksums in the header. A real
+// Game Boy refuses to boot a ROM with a bad logo or header checksum, but
+// ignores the global checksum, so any of these are only a sign that the
+// dump might be bad.
+func ValidateROM(data []byte, h *CartridgeHeader) []error {
+	var errs []error
+
+	if !LogoValid(data) {
+		errs = append(errs, errors.New("Nintendo logo does not match"))
+	}
+	if sum := ComputeHeaderChecksum(data); sum != h.HeaderChecksum {
+		errs = append(errs, fmt.Errorf("Header checksum mismatch: header says %02X, computed %02X", h.HeaderChecksum, sum))
+	}
+	if sum := ComputeGlobalChecksum(data); sum != h.GlobalChecksum {
+		errs = append(errs, fmt.Errorf("Global checksum mismatch: header says %04X, computed %04X", h.GlobalChecksum, sum))
+	}
+	if len(data) != h.ROMSize {
+		errs = append(errs, fmt.Errorf("ROM is %d bytes, header says %d", len(data), h.ROMSize))
+	}
+
+	return errs
+}
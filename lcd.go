@@ -1,4 +1,4 @@
-package main
+package gamebert
 
 import (
 	"fmt"
@@ -12,98 +12,103 @@ const (
 	viewportCols = 160
 )
 
-type LCD struct {
-	mb       *Motherboard
-	renderer *Renderer
-
-	lcdc *Register8Bit // 0xFF40
-	stat *Register8Bit // 0xFF41
-
-	scy  *Register8Bit // 0xFF42
-	scx  *Register8Bit // 0xFF43
-	ly   *Register8Bit // 0xFF44
-	lyc  *Register8Bit // 0xFF45
-	dma  *Register8Bit // 0xFF46
-	bgp  *Register8Bit // 0xFF47
-	obp0 *Register8Bit // 0xFF48
-	obp1 *Register8Bit // 0xFF49
-	wy   *Register8Bit // 0xFF4A
-	wx   *Register8Bit // 0xFF4B
-
-	flagLycInterrupt    *Flag // 6
-	flagOAMInterrupt    *Flag // 5
-	flagVBlankInterrupt *Flag // 4
-	flagHBlankInterrupt *Flag // 3
-	flagLyc             *Flag // 2
-	flagMode1           *Flag // 1
-	flagMode0           *Flag // 0
-
-	flagLcdEnabled          *Flag // 7
-	flagWindowmapSelect     *Flag // 6
-	flagWindowEnabled       *Flag // 5
-	flagTiledataSelect      *Flag // 4
-	flagBackgroundMapSelect *Flag // 3
-	flagSpriteHeight        *Flag // 2
-	flagSpriteEnabled       *Flag // 1
-	flagBackgroundEnabled   *Flag // 0
-
-	vRAM *RAMSegment
-	oam  *RAMSegment
+type lcd struct {
+	mb       *motherboard
+	renderer *renderer
+
+	lcdc *register8Bit // 0xFF40
+	stat *register8Bit // 0xFF41
+
+	scy  *register8Bit // 0xFF42
+	scx  *register8Bit // 0xFF43
+	ly   *register8Bit // 0xFF44
+	lyc  *register8Bit // 0xFF45
+	dma  *register8Bit // 0xFF46
+	bgp  *register8Bit // 0xFF47
+	obp0 *register8Bit // 0xFF48
+	obp1 *register8Bit // 0xFF49
+	wy   *register8Bit // 0xFF4A
+	wx   *register8Bit // 0xFF4B
+
+	flagLycInterrupt    *flag // 6
+	flagOAMInterrupt    *flag // 5
+	flagVBlankInterrupt *flag // 4
+	flagHBlankInterrupt *flag // 3
+	flagLyc             *flag // 2
+	flagMode1           *flag // 1
+	flagMode0           *flag // 0
+
+	flagLcdEnabled          *flag // 7
+	flagWindowmapSelect     *flag // 6
+	flagWindowEnabled       *flag // 5
+	flagTiledataSelect      *flag // 4
+	flagBackgroundMapSelect *flag // 3
+	flagSpriteHeight        *flag // 2
+	flagSpriteEnabled       *flag // 1
+	flagBackgroundEnabled   *flag // 0
+
+	vRAM *ramSegment
+	oam  *ramSegment
 
 	clock int
 }
 
-func NewLCD(mb *Motherboard) *LCD {
-	lcdc := &Register8Bit{name: "lcdc"}
-	stat := &Register8Bit{name: "stat"}
+func newLCD(mb *motherboard) *lcd {
+	lcdc := &register8Bit{name: "lcdc"}
+	stat := &register8Bit{name: "stat"}
 
-	lcd := &LCD{
+	lcd := &lcd{
 		mb: mb,
 
 		lcdc: lcdc,
 		stat: stat,
-		scy:  &Register8Bit{name: "scy"},
-		scx:  &Register8Bit{name: "scx"},
-		ly:   &Register8Bit{name: "ly"},
-		lyc:  &Register8Bit{name: "lyc"},
-		dma:  &Register8Bit{name: "dma"},
-		bgp:  &Register8Bit{name: "bgp"},
-		obp0: &Register8Bit{name: "obp0"},
-		obp1: &Register8Bit{name: "obp1"},
-		wy:   &Register8Bit{name: "wy"},
-		wx:   &Register8Bit{name: "wx"},
-
-		flagLycInterrupt:    &Flag{reg: stat, offset: 6, name: "lyci"},
-		flagOAMInterrupt:    &Flag{reg: stat, offset: 5, name: "oami"},
-		flagVBlankInterrupt: &Flag{reg: stat, offset: 4, name: "vbli"},
-		flagHBlankInterrupt: &Flag{reg: stat, offset: 3, name: "hbli"},
-		flagLyc:             &Flag{reg: stat, offset: 2, name: "lycf"},
-		flagMode1:           &Flag{reg: stat, offset: 1, name: "mod1"},
-		flagMode0:           &Flag{reg: stat, offset: 0, name: "mod0"},
-
-		flagLcdEnabled:          &Flag{reg: lcdc, offset: 7, name: "lcde"},
-		flagWindowmapSelect:     &Flag{reg: lcdc, offset: 6, name: "wmap"},
-		flagWindowEnabled:       &Flag{reg: lcdc, offset: 5, name: "wien"},
-		flagTiledataSelect:      &Flag{reg: lcdc, offset: 4, name: "tida"},
-		flagBackgroundMapSelect: &Flag{reg: lcdc, offset: 3, name: "bmap"},
-		flagSpriteHeight:        &Flag{reg: lcdc, offset: 2, name: "spht"},
-		flagSpriteEnabled:       &Flag{reg: lcdc, offset: 1, name: "spen"},
-		flagBackgroundEnabled:   &Flag{reg: lcdc, offset: 0, name: "bgen"},
-
-		vRAM:  NewRAMSegment(0x2000),
-		oam:   NewRAMSegment(0xA0),
+		scy:  &register8Bit{name: "scy"},
+		scx:  &register8Bit{name: "scx"},
+		ly:   &register8Bit{name: "ly"},
+		lyc:  &register8Bit{name: "lyc"},
+		dma:  &register8Bit{name: "dma"},
+		bgp:  &register8Bit{name: "bgp"},
+		obp0: &register8Bit{name: "obp0"},
+		obp1: &register8Bit{name: "obp1"},
+		wy:   &register8Bit{name: "wy"},
+		wx:   &register8Bit{name: "wx"},
+
+		flagLycInterrupt:    &flag{reg: stat, offset: 6, name: "lyci"},
+		flagOAMInterrupt:    &flag{reg: stat, offset: 5, name: "oami"},
+		flagVBlankInterrupt: &flag{reg: stat, offset: 4, name: "vbli"},
+		flagHBlankInterrupt: &flag{reg: stat, offset: 3, name: "hbli"},
+		flagLyc:             &flag{reg: stat, offset: 2, name: "lycf"},
+		flagMode1:           &flag{reg: stat, offset: 1, name: "mod1"},
+		flagMode0:           &flag{reg: stat, offset: 0, name: "mod0"},
+
+		flagLcdEnabled:          &flag{reg: lcdc, offset: 7, name: "lcde"},
+		flagWindowmapSelect:     &flag{reg: lcdc, offset: 6, name: "wmap"},
+		flagWindowEnabled:       &flag{reg: lcdc, offset: 5, name: "wien"},
+		flagTiledataSelect:      &flag{reg: lcdc, offset: 4, name: "tida"},
+		flagBackgroundMapSelect: &flag{reg: lcdc, offset: 3, name: "bmap"},
+		flagSpriteHeight:        &flag{reg: lcdc, offset: 2, name: "spht"},
+		flagSpriteEnabled:       &flag{reg: lcdc, offset: 1, name: "spen"},
+		flagBackgroundEnabled:   &flag{reg: lcdc, offset: 0, name: "bgen"},
+
+		vRAM:  newRAMSegment(0x2000),
+		oam:   newRAMSegment(0xA0),
 		clock: 0,
 	}
 	lcd.flagLcdEnabled.write(true)
 
-	renderer := NewRenderer(lcd)
+	renderer := newRenderer(lcd)
 	lcd.renderer = renderer
 
 	return lcd
 }
 
-func (lcd *LCD) writeByte(loc uint16, val uint8) {
-	lcd.getReg(loc).write(val)
+func (lcd *lcd) writeByte(loc uint16, val uint8) {
+	reg := lcd.getReg(loc)
+	if reg == nil {
+		lcd.mb.fault(&UnmappedAddressError{Addr: loc, Write: true})
+		return
+	}
+	reg.write(val)
 
 	// DMA transfer
 	if loc == 0xFF46 {
@@ -119,16 +124,22 @@ func (lcd *LCD) writeByte(loc uint16, val uint8) {
 	}
 }
 
-func (lcd LCD) readByte(loc uint16) uint8 {
+func (lcd *lcd) readByte(loc uint16) uint8 {
 	// XXX just for test logs
 	// if loc == 0xFF44 {
 	// 	return 0x90
 	// }
-	return lcd.getReg(loc).read()
+	reg := lcd.getReg(loc)
+	if reg == nil {
+		lcd.mb.fault(&UnmappedAddressError{Addr: loc})
+		return 0xFF
+	}
+	return reg.read()
 }
 
-func (lcd *LCD) getReg(loc uint16) *Register8Bit {
-	regMap := map[uint16]*Register8Bit{
+// getReg returns nil if there's no register at loc
+func (lcd *lcd) getReg(loc uint16) *register8Bit {
+	regMap := map[uint16]*register8Bit{
 		0xFF40: lcd.lcdc,
 		0xFF41: lcd.stat,
 		0xFF42: lcd.scy,
@@ -142,11 +153,7 @@ func (lcd *LCD) getReg(loc uint16) *Register8Bit {
 		0xFF4A: lcd.wy,
 		0xFF4B: lcd.wx,
 	}
-	reg, ok := regMap[loc]
-	if !ok {
-		panic(fmt.Sprintf("Unknown register at %04x", loc))
-	}
-	return reg
+	return regMap[loc]
 }
 
 const (
@@ -154,7 +161,7 @@ const (
 	mode3Limit = mode2Limit - 172
 )
 
-func (lcd *LCD) tick(cycles uint8) (bool, bool) {
+func (lcd *lcd) tick(cycles uint8) (bool, bool) {
 	if !lcd.flagLcdEnabled.read() {
 		lcd.clock = 0
 		lcd.ly.write(0)
@@ -195,7 +202,9 @@ func (lcd *LCD) tick(cycles uint8) (bool, bool) {
 		requestStatInterruptIfModeChanged = lcd.flagHBlankInterrupt.read()
 
 	default:
-		panic("This should never happen")
+		// Only reachable from a doctored save state
+		lcd.mb.fault(fmt.Errorf("LCD clock out of range: %d", lcd.clock))
+		return false, false
 	}
 
 	lcd.writeStatMode(nextMode)
@@ -228,7 +237,7 @@ func (lcd *LCD) tick(cycles uint8) (bool, bool) {
 	return requestVBlankInterrupt, requestStatInterrupt
 }
 
-func (lcd *LCD) readStatMode() uint8 {
+func (lcd *lcd) readStatMode() uint8 {
 	mode := uint8(0)
 	if lcd.flagMode0.read() {
 		mode += 1
@@ -240,24 +249,24 @@ func (lcd *LCD) readStatMode() uint8 {
 	return mode
 }
 
-func (lcd *LCD) writeStatMode(mode uint8) {
+func (lcd *lcd) writeStatMode(mode uint8) {
 	lcd.flagMode0.write(isBitSet8(mode, 0))
 	lcd.flagMode1.write(isBitSet8(mode, 1))
 }
 
-type Renderer struct {
-	lcd          *LCD
-	screenBuffer *Buffer2D
+type renderer struct {
+	lcd          *lcd
+	screenBuffer *buffer2D
 }
 
-func NewRenderer(lcd *LCD) *Renderer {
-	return &Renderer{
+func newRenderer(lcd *lcd) *renderer {
+	return &renderer{
 		lcd:          lcd,
-		screenBuffer: NewBuffer2D(viewportRows, viewportCols),
+		screenBuffer: newBuffer2D(viewportRows, viewportCols),
 	}
 }
 
-func (rd *Renderer) scanline() {
+func (rd *renderer) scanline() {
 	// This is the scanline we are drawing
 	ly := rd.lcd.ly.read()
 
@@ -1,123 +1,381 @@
-package main
-
-import (
-	"fmt"
-	"io/ioutil"
-
-	"github.com/faiface/pixel/pixelgl"
+package gamebert
+
+// Button is one of the Game Boy's eight buttons
+type Button int
+
+const (
+	ButtonRight Button = iota
+	ButtonLeft
+	ButtonUp
+	ButtonDown
+	ButtonA
+	ButtonB
+	ButtonSelect
+	ButtonStart
 )
 
-type JoypadIO struct {
-	joyp *Register8Bit
-	win  *pixelgl.Window
+// Input is where the joypad finds out which buttons are held. It's the only
+// thing the core needs from a frontend, so that it can run without one.
+type Input interface {
+	Pressed(b Button) bool
+}
+
+type joypadIO struct {
+	joyp  *register8Bit
+	input Input
 }
 
-func (j *JoypadIO) write(val uint8) {
+func (j *joypadIO) write(val uint8) {
 	j.joyp.setBit(4, isBitSet8(val, 4))
 	j.joyp.setBit(5, isBitSet8(val, 5))
 }
 
-func (j *JoypadIO) read() uint8 {
+// The low nibble of P1 for each button group, bit 0 first
+var (
+	directionButtons = [4]Button{ButtonRight, ButtonLeft, ButtonUp, ButtonDown}
+	actionButtons    = [4]Button{ButtonA, ButtonB, ButtonSelect, ButtonStart}
+)
+
+func (j *joypadIO) read() uint8 {
 	joyp := j.joyp.read()
 
 	joypadInput := uint8(0b1111)
-	if !isBitSet8(j.joyp.read(), 4) {
-		if j.win.Pressed(pixelgl.KeyRight) {
-			joypadInput = clearBit(joypadInput, 0)
-		}
-		if j.win.Pressed(pixelgl.KeyLeft) {
-			joypadInput = clearBit(joypadInput, 1)
-		}
-		if j.win.Pressed(pixelgl.KeyUp) {
-			joypadInput = clearBit(joypadInput, 2)
-		}
-		if j.win.Pressed(pixelgl.KeyDown) {
-			joypadInput = clearBit(joypadInput, 3)
-		}
-	} else {
-		// A
-		if j.win.Pressed(pixelgl.KeyA) {
-			joypadInput = clearBit(joypadInput, 0)
-		}
-		// B
-		if j.win.Pressed(pixelgl.KeyS) {
-			joypadInput = clearBit(joypadInput, 1)
-		}
-		// Select
-		if j.win.Pressed(pixelgl.KeyD) {
-			joypadInput = clearBit(joypadInput, 2)
-		}
-		// Start
-		if j.win.Pressed(pixelgl.KeyF) {
-			joypadInput = clearBit(joypadInput, 3)
+	if j.input == nil {
+		// Headless - nothing is ever pressed
+		return joyp | joypadInput
+	}
+
+	buttons := actionButtons
+	if !isBitSet8(joyp, 4) {
+		buttons = directionButtons
+	}
+	for i, b := range buttons {
+		if j.input.Pressed(b) {
+			joypadInput = clearBit(joypadInput, i)
 		}
 	}
 	return joyp | joypadInput
 }
 
-func NewJoypadIO(win *pixelgl.Window) *JoypadIO {
-	return &JoypadIO{
-		joyp: &Register8Bit{},
-		win:  win,
+// lineLow is whether any of P10-P13 are low, which means a button is held
+// in a group that's selected. It's what wakes the CPU from STOP.
+func (j *joypadIO) lineLow() bool {
+	return j.read()&0b1111 != 0b1111
+}
+
+func newJoypadIO(input Input) *joypadIO {
+	return &joypadIO{
+		joyp:  &register8Bit{},
+		input: input,
 	}
 }
 
-type Motherboard struct {
-	cpu *CPU
-	lcd *LCD
+type motherboard struct {
+	cpu *cpu
+	lcd *lcd
 
-	timer *Timer
+	timer *timer
+	apu   *APU
 
-	cart Cartridge
+	cart cartridge
+	// nil unless the cartridge needs ticking
+	cartTicker cartridgeTicker
+	// The ROM's checksums, which identify it in save states. They're read
+	// once up front, because banking can hide the header later on.
+	headerChecksum uint8
+	globalChecksum uint16
 
-	internalRAM0      *RAMSegment
-	internalRAM1      *RAMSegment
-	nonIOInternalRAM0 *RAMSegment
-	nonIOInternalRAM1 *RAMSegment
-	ioPorts           *RAMSegment
+	internalRAM0      *ramSegment
+	internalRAM1      *ramSegment
+	nonIOInternalRAM0 *ramSegment
+	nonIOInternalRAM1 *ramSegment
+	ioPorts           *ramSegment
 
-	joypadIO *JoypadIO
+	joypadIO *joypadIO
 
-	bootROM        *ROMSegment
+	bootROM        *romSegment
 	bootROMEnabled bool
 
 	debug bool
 
 	cycles uint64
+
+	// The first error the machine hit. Nothing runs while it's set, so
+	// everything is left as it was at the time.
+	err error
+	// A BreakError for the run methods to return, once
+	brk error
+
+	illegalOpcodePolicy IllegalOpcodePolicy
+	// Whether unused I/O registers stop the machine
+	strictIO bool
+}
+
+// Option configures a Machine as it's built
+type Option func(*machineConfig)
+
+type machineConfig struct {
+	bootROM             []byte
+	input               Input
+	illegalOpcodePolicy IllegalOpcodePolicy
+	sampleRate          int
+	rtcClock            RTCClock
+	rumble              func(on bool)
+	strictIO            bool
+}
+
+// WithBootROM runs bootROM before the cartridge
+func WithBootROM(bootROM []byte) Option {
+	return func(c *machineConfig) {
+		c.bootROM = bootROM
+	}
 }
 
-func NewMotherboard(cart Cartridge, win *pixelgl.Window) *Motherboard {
-	bootROMData, err := ioutil.ReadFile("dmg_boot.bin")
-	if err != nil {
-		panic(err)
+// WithInput reads the joypad from input
+func WithInput(input Input) Option {
+	return func(c *machineConfig) {
+		c.input = input
 	}
-	bootROM := NewROMSegment(bootROMData)
+}
 
-	timer := NewTimer()
+// WithSampleRate sets how many stereo samples a second the APU produces,
+// which should match whatever plays them. The default is DefaultSampleRate.
+func WithSampleRate(rate int) Option {
+	return func(c *machineConfig) {
+		c.sampleRate = rate
+	}
+}
+
+// WithRTCClock picks what an MBC3 cartridge's clock follows. The default is
+// RTCClockHost.
+func WithRTCClock(clock RTCClock) Option {
+	return func(c *machineConfig) {
+		c.rtcClock = clock
+	}
+}
 
-	mb := &Motherboard{
+// WithRumble calls rumble whenever a rumble cartridge switches its motor on
+// or off
+func WithRumble(rumble func(on bool)) Option {
+	return func(c *machineConfig) {
+		c.rumble = rumble
+	}
+}
+
+// IllegalOpcodePolicy is what happens when the CPU runs one of the opcodes
+// that don't exist on the DMG: D3, DB, DD, E3, E4, EB, EC, ED, F4, FC and FD
+type IllegalOpcodePolicy int
+
+const (
+	// IllegalOpcodeStop stops the machine with an IllegalOpcodeError
+	IllegalOpcodeStop IllegalOpcodePolicy = iota
+	// IllegalOpcodeLockUp hangs the CPU for good, as the hardware does.
+	// Nothing wakes it, not even interrupts, but the LCD, timer and sound
+	// keep running.
+	IllegalOpcodeLockUp
+	// IllegalOpcodeBreak locks up, but first returns a BreakError from the
+	// run methods, so that a debugger can take a look
+	IllegalOpcodeBreak
+)
+
+// WithIllegalOpcodePolicy picks what illegal opcodes do. The default is
+// IllegalOpcodeStop.
+func WithIllegalOpcodePolicy(p IllegalOpcodePolicy) Option {
+	return func(c *machineConfig) {
+		c.illegalOpcodePolicy = p
+	}
+}
+
+// WithStrictIO stops the machine with an UnmappedAddressError when the ROM
+// uses an I/O register that no Game Boy has. The hardware ignores them, as
+// we do by default, but touching one is usually a bug in homebrew.
+func WithStrictIO() Option {
+	return func(c *machineConfig) {
+		c.strictIO = true
+	}
+}
+
+// newMotherboard builds a DMG around cart. Without a boot ROM, it's skipped
+// and everything starts in the state it would have left. Without an input,
+// no buttons are ever pressed.
+func newMotherboard(cart cartridge, opts ...Option) *motherboard {
+	var cfg machineConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	timer := newTimer()
+
+	sampleRate := cfg.sampleRate
+	if sampleRate <= 0 {
+		sampleRate = DefaultSampleRate
+	}
+
+	mb := &motherboard{
 		cart:              cart,
 		timer:             timer,
-		internalRAM0:      NewRAMSegment(8 * 1024),
-		internalRAM1:      NewRAMSegment(0x7F),
-		nonIOInternalRAM0: NewRAMSegment(0x60),
-		nonIOInternalRAM1: NewRAMSegment(0x34),
-		ioPorts:           NewRAMSegment(0x4C),
-		joypadIO:          NewJoypadIO(win),
-		bootROM:           bootROM,
-		bootROMEnabled:    true,
-	}
-	cpu := NewCPU(mb)
+		apu:               newAPU(sampleRate),
+		internalRAM0:      newRAMSegment(8 * 1024),
+		internalRAM1:      newRAMSegment(0x7F),
+		nonIOInternalRAM0: newRAMSegment(0x60),
+		nonIOInternalRAM1: newRAMSegment(0x34),
+		ioPorts:           newRAMSegment(0x4C),
+		joypadIO:          newJoypadIO(cfg.input),
+
+		illegalOpcodePolicy: cfg.illegalOpcodePolicy,
+		strictIO:            cfg.strictIO,
+	}
+	if ct, ok := cart.(cartridgeTicker); ok {
+		mb.cartTicker = ct
+	}
+	mb.headerChecksum = cart.read(0x014D)
+	mb.globalChecksum = combine8(cart.read(0x014E), cart.read(0x014F))
+	switch c := cart.(type) {
+	case *mbc3:
+		c.setRTCClock(cfg.rtcClock)
+	case *mbc5:
+		c.setRumbleCallback(cfg.rumble)
+	}
+
+	cpu := newCPU(mb)
 	mb.cpu = cpu
 
-	lcd := NewLCD(mb)
+	lcd := newLCD(mb)
 	mb.lcd = lcd
 
+	if cfg.bootROM != nil {
+		mb.bootROM = newROMSegment(cfg.bootROM)
+		mb.bootROMEnabled = true
+	} else {
+		mb.initToPostBootROM()
+	}
+
 	return mb
 }
 
-func (mb *Motherboard) tick() {
+// IO register values on a DMG after the boot ROM hands over to the
+// cartridge. These go through writeByte so that each component sees them as
+// it would a normal write.
+// https://gbdev.io/pandocs/Power_Up_Sequence.html#hardware-registers
+var postBootIORegisters = []struct {
+	loc uint16
+	val uint8
+}{
+	{0xFF00, 0xCF}, // P1
+	{0xFF01, 0x00}, // SB
+	{0xFF02, 0x7E}, // SC
+	{0xFF05, 0x00}, // TIMA
+	{0xFF06, 0x00}, // TMA
+	{0xFF07, 0xF8}, // TAC
+	{0xFF0F, 0xE1}, // IF
+
+	// NR52 first, since the other sound registers can't be written while
+	// the APU is off.
+	{0xFF26, 0xF1}, // NR52
+	{0xFF10, 0x80}, // NR10
+	{0xFF11, 0xBF}, // NR11
+	{0xFF12, 0xF3}, // NR12
+	{0xFF13, 0xFF}, // NR13
+	{0xFF14, 0xBF}, // NR14
+	{0xFF16, 0x3F}, // NR21
+	{0xFF17, 0x00}, // NR22
+	{0xFF18, 0xFF}, // NR23
+	{0xFF19, 0xBF}, // NR24
+	{0xFF1A, 0x7F}, // NR30
+	{0xFF1B, 0xFF}, // NR31
+	{0xFF1C, 0x9F}, // NR32
+	{0xFF1D, 0xFF}, // NR33
+	{0xFF1E, 0xBF}, // NR34
+	{0xFF20, 0xFF}, // NR41
+	{0xFF21, 0x00}, // NR42
+	{0xFF22, 0x00}, // NR43
+	{0xFF23, 0xBF}, // NR44
+	{0xFF24, 0x77}, // NR50
+	{0xFF25, 0xF3}, // NR51
+
+	{0xFF40, 0x91}, // LCDC
+	{0xFF41, 0x85}, // STAT
+	{0xFF42, 0x00}, // SCY
+	{0xFF43, 0x00}, // SCX
+	{0xFF45, 0x00}, // LYC
+	{0xFF47, 0xFC}, // BGP
+	{0xFF48, 0xFF}, // OBP0
+	{0xFF49, 0xFF}, // OBP1
+	{0xFF4A, 0x00}, // WY
+	{0xFF4B, 0x00}, // WX
+	{0xFFFF, 0x00}, // IE
+}
+
+func (mb *motherboard) initToPostBootROM() {
+	mb.cpu.initToPostBootROM()
+
+	for _, reg := range postBootIORegisters {
+		mb.writeByte(reg.loc, reg.val)
+	}
+
+	// Writes to these have side effects (resetting DIV, starting a DMA
+	// transfer), so set them directly.
+	mb.timer.div.write(0xAB)
+	mb.lcd.dma.write(0xFF)
+	mb.lcd.ly.write(0x00)
+	mb.lcd.clock = 0
+
+	mb.bootROMEnabled = false
+}
+
+// fault stops the machine. Only the first error is kept, since anything
+// after it is likely to be fallout.
+func (mb *motherboard) fault(err error) {
+	if mb.err == nil {
+		mb.err = err
+	}
+}
+
+// breakFor has the run methods return a BreakError, without stopping the
+// machine
+func (mb *motherboard) breakFor(reason error) {
+	mb.brk = &BreakError{Reason: reason}
+}
+
+// runError is what the run methods should return after each tick: the error
+// that stopped the machine, or a pending break, or nil to carry on
+func (mb *motherboard) runError() error {
+	if mb.err != nil {
+		return mb.err
+	}
+	brk := mb.brk
+	mb.brk = nil
+	return brk
+}
+
+func (mb *motherboard) tick() {
+	if mb.err != nil {
+		return
+	}
+
 	cycles := mb.cpu.tick()
+	if mb.err != nil {
+		return
+	}
+
+	// STOP stops the main clock, and with it the LCD and timer. The sound
+	// keeps producing samples, with its frame sequencer held by DIV, so
+	// that the frontend still has something to play.
+	if !mb.cpu.stopped {
+		mb.tickClocked(cycles)
+	}
+
+	mb.apu.tick(cycles, mb.timer.div.read())
+
+	if mb.cartTicker != nil {
+		mb.cartTicker.tick(cycles)
+	}
+
+	mb.cycles += uint64(cycles)
+}
+
+// tickClocked runs everything that stops along with the CPU in STOP mode
+func (mb *motherboard) tickClocked(cycles uint8) {
 	vBlankInterruptRequested, statInterruptRequested := mb.lcd.tick(cycles)
 
 	if vBlankInterruptRequested {
@@ -132,17 +390,41 @@ func (mb *Motherboard) tick() {
 	if timerInterruptRequested {
 		mb.cpu.intTriggeredTimer.write(true)
 	}
-
-	mb.cycles += uint64(cycles)
 }
 
-func (mb Motherboard) readWord(loc uint16) uint16 {
+func (mb *motherboard) readWord(loc uint16) uint16 {
 	return combine8(mb.readByte(loc+1), mb.readByte(loc))
 }
 
-func (mb Motherboard) readByte(loc uint16) uint8 {
-	notImplemented := func() {
-		panic(fmt.Sprintf("Not implemented: reading memory from: %04x", loc))
+// unusedIO reports whether loc is in 0xFF4C-0xFF7F, but isn't a register on
+// any Game Boy. The rest of that range is the CGB's, and DMG games that
+// support the CGB poke at it, so it's kept as RAM like before.
+// https://gbdev.io/pandocs/Hardware_Reg_List.html
+func unusedIO(loc uint16) bool {
+	return loc == 0xFF4E ||
+		(loc >= 0xFF57 && loc <= 0xFF67) ||
+		(loc >= 0xFF6D && loc <= 0xFF6F) ||
+		loc == 0xFF71 ||
+		(loc >= 0xFF78 && loc <= 0xFF7F)
+}
+
+// peekByte reads from the memory map like readByte, but never stops the
+// machine, for looking at memory from outside
+func (mb *motherboard) peekByte(loc uint16) uint8 {
+	if unusedIO(loc) {
+		return 0xFF
+	}
+	return mb.readByte(loc)
+}
+
+// readByte reads from the memory map. Unused I/O registers read as 0xFF, as
+// an open bus does, and only stop the machine with WithStrictIO.
+func (mb *motherboard) readByte(loc uint16) uint8 {
+	unmapped := func() uint8 {
+		if mb.strictIO {
+			mb.fault(&UnmappedAddressError{Addr: loc})
+		}
+		return 0xFF
 	}
 
 	if loc < 0x4000 {
@@ -162,7 +444,7 @@ func (mb Motherboard) readByte(loc uint16) uint8 {
 	} else if loc < 0xFE00 {
 		return mb.readByte(loc - 0x2000)
 	} else if loc < 0xFEA0 {
-		return mb.lcd.oam.read(loc - 0xA0)
+		return mb.lcd.oam.read(loc - 0xFE00)
 	} else if loc < 0xFF00 {
 		return mb.nonIOInternalRAM0.read(loc - 0xFEA0)
 	} else if loc < 0xFF4C {
@@ -181,38 +463,37 @@ func (mb Motherboard) readByte(loc uint16) uint8 {
 			return mb.timer.tac.read()
 		} else if loc == 0xFF0F {
 			return mb.cpu.interruptsTriggered.read()
+		} else if loc >= 0xFF10 && loc < 0xFF40 {
+			return mb.apu.readByte(loc)
 		} else if loc < 0xFF40 {
-			// Sound
 			return 0x0
-		} else if loc < 0xFF4C {
-			return mb.lcd.readByte(loc)
 		} else {
-			// IO
-			notImplemented()
+			return mb.lcd.readByte(loc)
 		}
 	} else if loc < 0xFF80 {
+		if unusedIO(loc) {
+			return unmapped()
+		}
 		return mb.nonIOInternalRAM1.read(loc - 0xFF4C)
 	} else if loc < 0xFFFF {
 		return mb.internalRAM1.read(loc - 0xFF80)
-	} else if loc == 0xFFFF {
-		return mb.cpu.interruptsEnabled.read()
-	} else {
-		notImplemented()
 	}
 
-	panic("This should never happen")
+	return mb.cpu.interruptsEnabled.read()
 }
 
-func (mb *Motherboard) writeWord(loc, val uint16) {
+func (mb *motherboard) writeWord(loc, val uint16) {
 	hi, lo := chunk16(val)
 
 	mb.writeByte(loc, lo)
 	mb.writeByte(loc+1, hi)
 }
 
-func (mb *Motherboard) writeByte(loc uint16, val uint8) {
-	notImplemented := func() {
-		panic(fmt.Sprintf("Not implemented: writine memory to: %04x", loc))
+func (mb *motherboard) writeByte(loc uint16, val uint8) {
+	unmapped := func() {
+		if mb.strictIO {
+			mb.fault(&UnmappedAddressError{Addr: loc, Write: true})
+		}
 	}
 
 	if loc < 0x4000 {
@@ -249,25 +530,24 @@ func (mb *Motherboard) writeByte(loc uint16, val uint8) {
 			mb.timer.tac.write(val & 0b111)
 		} else if loc == 0xFF0F {
 			mb.cpu.interruptsTriggered.write(val)
+		} else if loc >= 0xFF10 && loc < 0xFF40 {
+			mb.apu.writeByte(loc, val)
 		} else if loc < 0xFF40 {
-			// Sound
-			// TODO
-		} else if loc < 0xFF4C {
-			mb.lcd.writeByte(loc, val)
+			// Unused
 		} else {
-			notImplemented()
+			mb.lcd.writeByte(loc, val)
 		}
 	} else if loc < 0xFF80 {
-		if mb.bootROMEnabled && loc == 0xFF50 && (val == 0x1 || val == 0x11) {
+		if unusedIO(loc) {
+			unmapped()
+		} else if mb.bootROMEnabled && loc == 0xFF50 && (val == 0x1 || val == 0x11) {
 			mb.bootROMEnabled = false
 		} else {
 			mb.nonIOInternalRAM1.write(loc-0xFF4C, val)
 		}
 	} else if loc < 0xFFFF {
 		mb.internalRAM1.write(loc-0xFF80, val)
-	} else if loc == 0xFFFF {
-		mb.cpu.interruptsEnabled.write(val)
 	} else {
-		notImplemented()
+		mb.cpu.interruptsEnabled.write(val)
 	}
 }
@@ -0,0 +1,214 @@
+package gamebert
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostBootROMState(t *testing.T) {
+	cart, err := newCartridgeFromData(makeROM(0x8000, 0x00, 0x00, 0x00, "TETRIS"))
+	assert.NoError(t, err)
+
+	mb := newMotherboard(cart)
+
+	assert.False(t, mb.bootROMEnabled)
+	assert.Equal(t, uint16(0x0100), mb.cpu.pc.read())
+	assert.Equal(t, uint16(0xFFFE), mb.cpu.sp.read())
+	assert.Equal(t, uint16(0x01B0), mb.cpu.af.read())
+	assert.False(t, mb.cpu.masterInterruptsEnabled)
+
+	assert.Equal(t, uint8(0x91), mb.readByte(0xFF40))
+	assert.Equal(t, uint8(0xFC), mb.readByte(0xFF47))
+	assert.Equal(t, uint8(0xAB), mb.readByte(0xFF04))
+	assert.Equal(t, uint8(0xE1), mb.readByte(0xFF0F))
+	assert.Equal(t, uint8(0x00), mb.readByte(0xFFFF))
+
+	// Reads at 0x0000-0x00FF go to the cartridge, not a boot ROM
+	assert.Equal(t, cart.read(0x0000), mb.readByte(0x0000))
+}
+
+func TestUnmappedIO(t *testing.T) {
+	cart, err := newCartridgeFromData(makeROM(0x8000, 0x00, 0x00, 0x00, "TETRIS"))
+	assert.NoError(t, err)
+
+	// Unused registers are open bus, as on the hardware
+	mb := newMotherboard(cart)
+	mb.writeByte(0xFF7F, 0x12)
+	assert.Equal(t, uint8(0xFF), mb.readByte(0xFF7F))
+	assert.Equal(t, uint8(0xFF), mb.readByte(0xFF60))
+	assert.NoError(t, mb.err)
+
+	// CGB registers are left alone for dual-mode games
+	mb.writeByte(0xFF4F, 0x01)
+	assert.Equal(t, uint8(0x01), mb.readByte(0xFF4F))
+
+	mb = newMotherboard(cart, WithStrictIO())
+	mb.writeByte(0xFF4F, 0x01)
+	assert.Equal(t, uint8(0xFF), mb.peekByte(0xFF60))
+	assert.NoError(t, mb.err)
+
+	assert.Equal(t, uint8(0xFF), mb.readByte(0xFF60))
+	assert.ErrorIs(t, mb.err, ErrUnmappedAddress)
+	assert.Equal(t, &UnmappedAddressError{Addr: 0xFF60}, mb.err)
+
+	mb = newMotherboard(cart, WithStrictIO())
+	mb.writeByte(0xFF7F, 0x12)
+	assert.Equal(t, &UnmappedAddressError{Addr: 0xFF7F, Write: true}, mb.err)
+}
+
+func newPowerTestMotherboard(t *testing.T, program []byte, input Input) *motherboard {
+	rom := makeROM(0x8000, 0x00, 0x00, 0x00, "POWER")
+	copy(rom[0x0100:], program)
+
+	cart, err := newCartridgeFromData(rom)
+	assert.NoError(t, err)
+	return newMotherboard(cart, WithInput(input))
+}
+
+func TestStop(t *testing.T) {
+	held := fakeInput{}
+	mb := newPowerTestMotherboard(t, []byte{
+		0x3E, 0x20, // LD A,20
+		0xE0, 0x00, // LDH (00),A - select the direction buttons
+		0x10, 0x00, // STOP 0
+		0x3C,       // INC A
+		0x18, 0xFE, // JR -2
+	}, held)
+
+	for mb.cpu.pc.read() != 0x0106 {
+		mb.tick()
+	}
+	assert.True(t, mb.cpu.stopped)
+	assert.Equal(t, uint8(0), mb.readByte(0xFF04), "DIV is reset")
+
+	// The CPU, LCD and timer stay put however long it's left
+	ly := mb.readByte(0xFF44)
+	for i := 0; i < 10000; i++ {
+		mb.tick()
+	}
+	assert.Equal(t, uint16(0x0106), mb.cpu.pc.read())
+	assert.Equal(t, ly, mb.readByte(0xFF44))
+	assert.Equal(t, uint8(0), mb.readByte(0xFF04))
+	assert.Equal(t, uint8(0x20), mb.cpu.a.read())
+
+	// A button outside the selected group doesn't wake it...
+	held[ButtonStart] = true
+	mb.tick()
+	assert.True(t, mb.cpu.stopped)
+
+	// ...but one in it does
+	held[ButtonLeft] = true
+	mb.tick()
+	assert.False(t, mb.cpu.stopped)
+	assert.Equal(t, uint8(0x21), mb.cpu.a.read())
+}
+
+func TestStopWithButtonHeld(t *testing.T) {
+	mb := newPowerTestMotherboard(t, []byte{
+		0x3E, 0x10, // LD A,10
+		0xE0, 0x00, // LDH (00),A - select the action buttons
+		0x10, 0x00, // STOP 0
+	}, fakeInput{ButtonA: true})
+
+	for i := 0; i < 3; i++ {
+		mb.tick()
+	}
+
+	// It halts rather than stopping, and leaves DIV alone
+	assert.False(t, mb.cpu.stopped)
+	assert.True(t, mb.cpu.halted)
+	assert.Equal(t, uint16(0x0106), mb.cpu.pc.read())
+	assert.NotEqual(t, uint8(0), mb.readByte(0xFF04))
+}
+
+// Clears IF, enables only the vblank interrupt, then halts
+var haltTestProgram = []byte{
+	0xAF,       // XOR A
+	0xE0, 0x0F, // LDH (0F),A
+	0x3C,       // INC A
+	0xE0, 0xFF, // LDH (FF),A
+}
+
+// tickUntilAwake runs a halted CPU until it wakes, and returns how long the
+// waking tick took
+func tickUntilAwake(t *testing.T, mb *motherboard) uint64 {
+	for i := 0; i < 100000; i++ {
+		before := mb.cycles
+		mb.tick()
+		if !mb.cpu.halted {
+			return mb.cycles - before
+		}
+	}
+	t.Fatal("never woke up")
+	return 0
+}
+
+func TestHaltWithoutIME(t *testing.T) {
+	mb := newPowerTestMotherboard(t, append(haltTestProgram,
+		0x76,       // HALT
+		0x04,       // INC B
+		0x18, 0xFE, // JR -2
+	), nil)
+
+	for !mb.cpu.halted {
+		mb.tick()
+	}
+	assert.Equal(t, uint16(0x0107), mb.cpu.pc.read())
+
+	// Vblank wakes it, and it carries on without servicing the interrupt,
+	// taking 4 cycles to wake before running INC B
+	assert.Equal(t, uint64(haltWakeCycles+4), tickUntilAwake(t, mb))
+	assert.Equal(t, uint16(0x0108), mb.cpu.pc.read())
+	assert.Equal(t, uint8(1), mb.cpu.b.read())
+	assert.True(t, mb.cpu.intTriggeredVBlank.read())
+}
+
+func TestHaltWithIME(t *testing.T) {
+	rom := makeROM(0x8000, 0x00, 0x00, 0x00, "POWER")
+	copy(rom[0x0100:], append(haltTestProgram,
+		0xFB, // EI
+		0x76, // HALT
+		0x00, // NOP
+	))
+	// Spin in the vblank handler
+	copy(rom[0x0040:], []byte{0x18, 0xFE})
+	cart, err := newCartridgeFromData(rom)
+	assert.NoError(t, err)
+	mb := newMotherboard(cart)
+
+	for !mb.cpu.halted {
+		mb.tick()
+	}
+	tickUntilAwake(t, mb)
+
+	assert.Equal(t, uint16(0x0040), mb.cpu.pc.read())
+	assert.Equal(t, uint16(0x0108), mb.readWord(mb.cpu.sp.read()), "returns to after the HALT")
+	assert.False(t, mb.cpu.intTriggeredVBlank.read())
+}
+
+func TestHaltBug(t *testing.T) {
+	// IME is clear after the boot ROM, and vblank is already pending
+	mb := newPowerTestMotherboard(t, []byte{
+		0x3E, 0x01, // LD A,01
+		0xE0, 0xFF, // LDH (FF),A
+		0x76,       // HALT
+		0x3E, 0x14, // LD A,14
+		0x18, 0xFE, // JR -2
+	}, nil)
+
+	for mb.cpu.pc.read() != 0x0105 {
+		mb.tick()
+	}
+	assert.False(t, mb.cpu.halted)
+
+	// The LD's opcode is read again as its operand, and then the operand is
+	// run as an instruction of its own, INC D
+	d := mb.cpu.d.read()
+	mb.tick()
+	assert.Equal(t, uint8(0x3E), mb.cpu.a.read())
+	assert.Equal(t, uint16(0x0106), mb.cpu.pc.read())
+	mb.tick()
+	assert.Equal(t, d+1, mb.cpu.d.read())
+	assert.Equal(t, uint16(0x0107), mb.cpu.pc.read())
+}
@@ -1,92 +1,106 @@
-package main
+package gamebert
 
 import (
+	"bytes"
+	_ "embed"
 	"encoding/json"
-	"os"
+	"fmt"
 	"strconv"
 	"strings"
 )
 
-type Opcodes struct {
-	Unprefixed map[uint8]*Opcode
-	Cbprefixed map[uint8]*Opcode
+//go:embed opcodes.json
+var opcodesJSON []byte
+
+// opcodeTable is indexed by the opcode byte itself. Unprefixed has nil
+// entries for the opcodes that don't exist on the DMG.
+type opcodeTable struct {
+	Unprefixed [256]*opcodeInfo
+	Cbprefixed [256]*opcodeInfo
 }
-type Opcode struct {
+type opcodeInfo struct {
 	Cycles []int
 	Addr   uint8
 	Length uint8
 }
 
-func (o *Opcodes) GetUnprefixed(op uint8) *Opcode {
-	opcode, ok := o.Unprefixed[op]
-	if !ok {
-		panic("Unrecognized unprefixed opcode")
+func (o *opcodeTable) getUnprefixed(op uint8) (*opcodeInfo, error) {
+	opcode := o.Unprefixed[op]
+	if opcode == nil {
+		return nil, fmt.Errorf("%w: %02X", ErrIllegalOpcode, op)
 	}
 
-	return opcode
+	return opcode, nil
 }
-func (o *Opcodes) GetCbPrefixed(op uint8) *Opcode {
-	opcode, ok := o.Cbprefixed[op]
-	if !ok {
-		panic("Unrecognized unprefixed opcode")
+func (o *opcodeTable) getCbPrefixed(op uint8) (*opcodeInfo, error) {
+	opcode := o.Cbprefixed[op]
+	if opcode == nil {
+		return nil, fmt.Errorf("%w: CB %02X", ErrIllegalOpcode, op)
 	}
 
-	return opcode
+	return opcode, nil
 }
 
-func LoadOpcodes() *Opcodes {
-	// TODO: don't load relatively
-	f, err := os.Open("./opcodes.json")
-	if err != nil {
-		panic(err)
-	}
-
-	dec := json.NewDecoder(f)
+// loadOpcodes parses the opcode metadata that is compiled into the binary
+func loadOpcodes() (*opcodeTable, error) {
+	dec := json.NewDecoder(bytes.NewReader(opcodesJSON))
 	dec.DisallowUnknownFields()
 
-	opsJSON := OpcodesJSON{}
+	opsJSON := opcodeTableJSON{}
 	if err := dec.Decode(&opsJSON); err != nil {
-		panic(err)
+		return nil, fmt.Errorf("Parsing opcodes: %w", err)
 	}
 
-	unprefixed := make(map[uint8]*Opcode, 0)
+	ops := &opcodeTable{}
 	for _, oj := range opsJSON.Unprefixed {
-		op := formatOpcodeJSON(&oj)
-		unprefixed[op.Addr] = op
+		op, err := formatOpcodeJSON(&oj)
+		if err != nil {
+			return nil, err
+		}
+		ops.Unprefixed[op.Addr] = op
 	}
-	prefixed := make(map[uint8]*Opcode, 0)
 	for _, oj := range opsJSON.Cbprefixed {
-		op := formatOpcodeJSON(&oj)
-		prefixed[op.Addr] = op
+		op, err := formatOpcodeJSON(&oj)
+		if err != nil {
+			return nil, err
+		}
+		ops.Cbprefixed[op.Addr] = op
 	}
 
-	return &Opcodes{
-		Unprefixed: unprefixed,
-		Cbprefixed: prefixed,
+	return ops, nil
+}
+
+// mustLoadOpcodes is for the package's own table. The JSON is compiled in,
+// so the only way this can fail is a broken build, which the tests catch.
+func mustLoadOpcodes() *opcodeTable {
+	ops, err := loadOpcodes()
+	if err != nil {
+		panic(err)
 	}
+	return ops
 }
 
-func formatOpcodeJSON(oj *OpcodeJSON) *Opcode {
+func formatOpcodeJSON(oj *opcodeJSON) (*opcodeInfo, error) {
 	addr, err := strconv.ParseUint(
 		strings.Replace(oj.Addr, "0x", "", -1),
 		16, 64)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("Bad opcode address %q: %w", oj.Addr, err)
 	}
 
-	op := Opcode{
+	op := opcodeInfo{
 		Addr:   uint8(addr),
 		Length: uint8(oj.Length),
 		Cycles: oj.Cycles,
 	}
-	return &op
+	return &op, nil
 }
 
-type OpcodesJSON struct {
-	Unprefixed map[string]OpcodeJSON `json:"unprefixed"`
-	Cbprefixed map[string]OpcodeJSON `json:"cbprefixed"`
+type opcodeTableJSON struct {
+	Unprefixed map[string]opcodeJSON `json:"unprefixed"`
+	Cbprefixed map[string]opcodeJSON `json:"cbprefixed"`
 }
-type OpcodeJSON struct {
+type opcodeJSON struct {
 	Mnemonic string   `json:"mnemonic"`
 	Length   int      `json:"length"`
 	Cycles   []int    `json:"cycles"`
@@ -0,0 +1,73 @@
+package gamebert
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadOpcodes(t *testing.T) {
+	ops, err := loadOpcodes()
+	assert.NoError(t, err)
+
+	illegal := map[uint8]bool{
+		0xD3: true, 0xDB: true, 0xDD: true, 0xE3: true, 0xE4: true, 0xEB: true,
+		0xEC: true, 0xED: true, 0xF4: true, 0xFC: true, 0xFD: true,
+	}
+	for i := 0; i < 256; i++ {
+		op := uint8(i)
+		_, err := ops.getUnprefixed(op)
+		if illegal[op] {
+			assert.ErrorIs(t, err, ErrIllegalOpcode, "%02X", op)
+		} else {
+			assert.NoError(t, err, "%02X", op)
+		}
+
+		_, err = ops.getCbPrefixed(op)
+		assert.NoError(t, err, "CB %02X", op)
+	}
+}
+
+func BenchmarkOpcodeLookup(b *testing.B) {
+	var valid []uint8
+	for i := 0; i < 256; i++ {
+		if opcodes.Unprefixed[uint8(i)] != nil {
+			valid = append(valid, uint8(i))
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		op := valid[i%len(valid)]
+		opcodes.getUnprefixed(op)
+		opcodes.getCbPrefixed(op)
+	}
+}
+
+// Runs a ROM that loops over a spread of ordinary instructions, to measure
+// the whole fetch/decode/execute path.
+func BenchmarkCPUTick(b *testing.B) {
+	rom := makeROM(0x8000, 0x00, 0x00, 0x00, "BENCH")
+	program := []byte{
+		0x04,       // INC B
+		0x0E, 0x12, // LD C,d8
+		0x80,       // ADD A,B
+		0xCB, 0x11, // RL C
+		0xA9,             // XOR C
+		0x21, 0x00, 0xC0, // LD HL,d16
+		0x77,       // LD (HL),A
+		0x18, 0xF3, // JR -13
+	}
+	copy(rom[0x0100:], program)
+
+	cart, err := newCartridgeFromData(rom)
+	if err != nil {
+		b.Fatal(err)
+	}
+	mb := newMotherboard(cart)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mb.cpu.tick()
+	}
+}
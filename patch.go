@@ -0,0 +1,354 @@
+package gamebert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+// Patch formats that we know how to apply, in the order that we look for
+// them beside a ROM.
+var patchExts = []string{".ips", ".ups", ".bps"}
+
+// findPatch looks for a patch with the same name as the ROM. It returns ""
+// if there isn't one.
+func findPatch(romPath string) string {
+	base := romBasePath(romPath)
+	for _, ext := range patchExts {
+		if _, err := os.Stat(base + ext); err == nil {
+			return base + ext
+		}
+	}
+	return ""
+}
+
+func applyPatchFile(rom []byte, patchPath string) ([]byte, error) {
+	patch, err := ioutil.ReadFile(patchPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("Patch not found: %s", patchPath)
+	} else if err != nil {
+		return nil, err
+	}
+
+	patched, err := applyPatch(rom, patch)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to apply %s: %w", patchPath, err)
+	}
+	return patched, nil
+}
+
+// applyPatch detects the patch format from its magic bytes and returns a
+// patched copy of rom.
+func applyPatch(rom, patch []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(patch, []byte("PATCH")):
+		return applyIPS(rom, patch)
+	case bytes.HasPrefix(patch, []byte("UPS1")):
+		return applyUPS(rom, patch)
+	case bytes.HasPrefix(patch, []byte("BPS1")):
+		return applyBPS(rom, patch)
+	default:
+		return nil, errors.New("Unrecognised patch format")
+	}
+}
+
+// ErrBadPatch is a UPS or BPS patch whose sizes or offsets make no sense,
+// whether it's corrupt or made to crash us
+var ErrBadPatch = errors.New("Bad patch")
+
+var errPatchTruncated = errors.New("Patch is truncated")
+
+// https://zerosoft.zophar.net/ips.php
+func applyIPS(rom, patch []byte) ([]byte, error) {
+	out := append([]byte{}, rom...)
+
+	// Writes past the end of the ROM grow it
+	grow := func(size int) {
+		if size > len(out) {
+			out = append(out, make([]byte, size-len(out))...)
+		}
+	}
+
+	pos := 5
+	for {
+		if pos+3 > len(patch) {
+			return nil, errPatchTruncated
+		}
+		if string(patch[pos:pos+3]) == "EOF" {
+			pos += 3
+			break
+		}
+
+		if pos+5 > len(patch) {
+			return nil, errPatchTruncated
+		}
+		offset := int(patch[pos])<<16 | int(patch[pos+1])<<8 | int(patch[pos+2])
+		size := int(binary.BigEndian.Uint16(patch[pos+3:]))
+		pos += 5
+
+		if size == 0 {
+			// Run-length encoded record
+			if pos+3 > len(patch) {
+				return nil, errPatchTruncated
+			}
+			runSize := int(binary.BigEndian.Uint16(patch[pos:]))
+			val := patch[pos+2]
+			pos += 3
+
+			grow(offset + runSize)
+			for i := 0; i < runSize; i++ {
+				out[offset+i] = val
+			}
+		} else {
+			if pos+size > len(patch) {
+				return nil, errPatchTruncated
+			}
+			grow(offset + size)
+			copy(out[offset:], patch[pos:pos+size])
+			pos += size
+		}
+	}
+
+	// An optional truncation length can follow EOF
+	if pos+3 <= len(patch) {
+		size := int(patch[pos])<<16 | int(patch[pos+1])<<8 | int(patch[pos+2])
+		if size < len(out) {
+			out = out[:size]
+		}
+	}
+
+	return out, nil
+}
+
+// patchReader reads the variable-length ints shared by UPS and BPS
+type patchReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *patchReader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errPatchTruncated
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *patchReader) varint() (uint64, error) {
+	val, shift := uint64(0), uint64(1)
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift > math.MaxUint64>>7 {
+			return 0, fmt.Errorf("%w: number is too big", ErrBadPatch)
+		}
+		val += uint64(b&0x7F) * shift
+		if b&0x80 != 0 {
+			return val, nil
+		}
+		shift <<= 7
+		val += shift
+	}
+}
+
+// checkTargetSize stops a patch from asking for more memory than any ROM
+// needs
+func checkTargetSize(size uint64) error {
+	if size > maxArchivedROMSize {
+		return fmt.Errorf("%w: patched ROM would be %d bytes, over the %d a ROM can be", ErrBadPatch, size, maxArchivedROMSize)
+	}
+	return nil
+}
+
+// checkPatchCRCs validates the 12-byte footer shared by UPS and BPS: the
+// CRC32s of the source, the target and the patch itself.
+func checkPatchCRCs(src, target, patch []byte) error {
+	footer := patch[len(patch)-12:]
+	if crc32.ChecksumIEEE(patch[:len(patch)-4]) != binary.LittleEndian.Uint32(footer[8:]) {
+		return errors.New("Patch checksum mismatch - the patch is corrupt")
+	}
+	if crc32.ChecksumIEEE(src) != binary.LittleEndian.Uint32(footer[0:]) {
+		return errors.New("ROM checksum mismatch - this patch is for a different ROM")
+	}
+	if target != nil && crc32.ChecksumIEEE(target) != binary.LittleEndian.Uint32(footer[4:]) {
+		return errors.New("Patched ROM checksum mismatch")
+	}
+	return nil
+}
+
+// https://www.romhacking.net/documents/392/
+func applyUPS(rom, patch []byte) ([]byte, error) {
+	if len(patch) < 4+12 {
+		return nil, errPatchTruncated
+	}
+	if err := checkPatchCRCs(rom, nil, patch); err != nil {
+		return nil, err
+	}
+
+	r := &patchReader{data: patch[:len(patch)-12], pos: 4}
+	srcSize, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	targetSize, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if srcSize != uint64(len(rom)) {
+		return nil, fmt.Errorf("ROM is %d bytes, patch expects %d", len(rom), srcSize)
+	}
+	if err := checkTargetSize(targetSize); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, targetSize)
+	copy(out, rom)
+
+	offset := uint64(0)
+	for r.pos < len(r.data) {
+		skip, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		offset += skip
+
+		// XOR bytes in until a zero byte, which ends the hunk
+		for {
+			b, err := r.byte()
+			if err != nil {
+				return nil, err
+			}
+			if b == 0 {
+				offset++
+				break
+			}
+			if offset < targetSize {
+				out[offset] ^= b
+			}
+			offset++
+		}
+	}
+
+	if err := checkPatchCRCs(rom, out, patch); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// https://github.com/blakesmith/rombp/blob/master/docs/bps_spec.md
+func applyBPS(rom, patch []byte) ([]byte, error) {
+	if len(patch) < 4+12 {
+		return nil, errPatchTruncated
+	}
+	if err := checkPatchCRCs(rom, nil, patch); err != nil {
+		return nil, err
+	}
+
+	r := &patchReader{data: patch[:len(patch)-12], pos: 4}
+	srcSize, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	targetSize, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	metadataSize, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if metadataSize > uint64(len(r.data)-r.pos) {
+		return nil, errPatchTruncated
+	}
+	r.pos += int(metadataSize)
+	if srcSize != uint64(len(rom)) {
+		return nil, fmt.Errorf("ROM is %d bytes, patch expects %d", len(rom), srcSize)
+	}
+	if err := checkTargetSize(targetSize); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, targetSize)
+	outPos := 0
+	srcRel, targetRel := 0, 0
+
+	// Relative offsets are stored as a sign bit and a magnitude
+	readOffset := func() (int, error) {
+		v, err := r.varint()
+		if err != nil {
+			return 0, err
+		}
+		if v&1 != 0 {
+			return -int(v >> 1), nil
+		}
+		return int(v >> 1), nil
+	}
+
+	for r.pos < len(r.data) {
+		data, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		if data>>2 >= uint64(len(out)-outPos) {
+			return nil, errors.New("Patch writes past the end of the ROM")
+		}
+		length := int(data>>2) + 1
+
+		switch data & 3 {
+		case 0: // SourceRead
+			if outPos+length > len(rom) {
+				return nil, errors.New("Patch reads past the end of the ROM")
+			}
+			copy(out[outPos:], rom[outPos:outPos+length])
+			outPos += length
+		case 1: // TargetRead
+			if r.pos+length > len(r.data) {
+				return nil, errPatchTruncated
+			}
+			copy(out[outPos:], r.data[r.pos:r.pos+length])
+			r.pos += length
+			outPos += length
+		case 2: // SourceCopy
+			delta, err := readOffset()
+			if err != nil {
+				return nil, err
+			}
+			srcRel += delta
+			if srcRel < 0 || srcRel > len(rom)-length {
+				return nil, errors.New("Patch reads past the end of the ROM")
+			}
+			copy(out[outPos:], rom[srcRel:srcRel+length])
+			srcRel += length
+			outPos += length
+		case 3: // TargetCopy
+			delta, err := readOffset()
+			if err != nil {
+				return nil, err
+			}
+			targetRel += delta
+			if targetRel < 0 || targetRel > len(out)-length {
+				return nil, errors.New("Patch copies from outside the ROM")
+			}
+			// Copy byte by byte, since the regions can overlap
+			for i := 0; i < length; i++ {
+				out[outPos] = out[targetRel]
+				outPos++
+				targetRel++
+			}
+		}
+	}
+
+	if err := checkPatchCRCs(rom, out, patch); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,146 @@
+package gamebert
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodePatchVarint(v uint64) []byte {
+	var out []byte
+	for {
+		x := byte(v & 0x7F)
+		v >>= 7
+		if v == 0 {
+			return append(out, 0x80|x)
+		}
+		out = append(out, x)
+		v--
+	}
+}
+
+func appendPatchCRCs(patch, src, target []byte) []byte {
+	patch = binary.LittleEndian.AppendUint32(patch, crc32.ChecksumIEEE(src))
+	patch = binary.LittleEndian.AppendUint32(patch, crc32.ChecksumIEEE(target))
+	return binary.LittleEndian.AppendUint32(patch, crc32.ChecksumIEEE(patch))
+}
+
+func TestApplyIPS(t *testing.T) {
+	rom := []byte{0, 1, 2, 3, 4, 5, 6, 7}
+	patch := []byte("PATCH")
+	// Plain record: 2 bytes at offset 1
+	patch = append(patch, 0x00, 0x00, 0x01, 0x00, 0x02, 0xAA, 0xBB)
+	// RLE record: 3 bytes of 0xCC at offset 7, growing the ROM
+	patch = append(patch, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x03, 0xCC)
+	patch = append(patch, []byte("EOF")...)
+
+	out, err := applyPatch(rom, patch)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 0xAA, 0xBB, 3, 4, 5, 6, 0xCC, 0xCC, 0xCC}, out)
+	// The original is left alone
+	assert.Equal(t, uint8(1), rom[1])
+
+	_, err = applyPatch(rom, patch[:len(patch)-3])
+	assert.Error(t, err)
+}
+
+func TestApplyUPS(t *testing.T) {
+	rom := []byte{0, 1, 2, 3, 4, 5, 6, 7}
+	target := []byte{0, 1, 2, 0x33, 0x44, 5, 6, 7, 8}
+
+	patch := []byte("UPS1")
+	patch = append(patch, encodePatchVarint(uint64(len(rom)))...)
+	patch = append(patch, encodePatchVarint(uint64(len(target)))...)
+	patch = append(patch, encodePatchVarint(3)...)
+	patch = append(patch, 3^0x33, 4^0x44, 0x00)
+	// Offsets are relative to the byte after the previous hunk
+	patch = append(patch, encodePatchVarint(2)...)
+	patch = append(patch, 8, 0x00)
+	patch = appendPatchCRCs(patch, rom, target)
+
+	out, err := applyPatch(rom, patch)
+	assert.NoError(t, err)
+	assert.Equal(t, target, out)
+
+	// Wrong source ROM
+	_, err = applyPatch([]byte{9, 9, 9, 9, 9, 9, 9, 9}, patch)
+	assert.Error(t, err)
+}
+
+func TestApplyBPS(t *testing.T) {
+	rom := []byte{0, 1, 2, 3, 4, 5, 6, 7}
+	target := []byte{0, 1, 2, 3, 0xAA, 0xBB, 0, 1, 0xAA, 0xBB}
+
+	patch := []byte("BPS1")
+	patch = append(patch, encodePatchVarint(uint64(len(rom)))...)
+	patch = append(patch, encodePatchVarint(uint64(len(target)))...)
+	patch = append(patch, encodePatchVarint(0)...)
+	// SourceRead 4
+	patch = append(patch, encodePatchVarint((4-1)<<2|0)...)
+	// TargetRead 2
+	patch = append(patch, encodePatchVarint((2-1)<<2|1)...)
+	patch = append(patch, 0xAA, 0xBB)
+	// SourceCopy 2 from offset 0
+	patch = append(patch, encodePatchVarint((2-1)<<2|2)...)
+	patch = append(patch, encodePatchVarint(0)...)
+	// TargetCopy 2 from offset 4
+	patch = append(patch, encodePatchVarint((2-1)<<2|3)...)
+	patch = append(patch, encodePatchVarint(4<<1)...)
+	patch = appendPatchCRCs(patch, rom, target)
+
+	out, err := applyPatch(rom, patch)
+	assert.NoError(t, err)
+	assert.Equal(t, target, out)
+
+	// Corrupt the patch body
+	patch[6] ^= 0xFF
+	_, err = applyPatch(rom, patch)
+	assert.Error(t, err)
+}
+
+func TestApplyBadPatch(t *testing.T) {
+	rom := []byte{0, 1, 2, 3, 4, 5, 6, 7}
+
+	// Every varint byte without its end bit, past what fits in 64 bits
+	overflow := make([]byte, 11)
+
+	for _, tc := range []struct {
+		name  string
+		patch []byte
+	}{
+		{"UPS target too big", append(append([]byte("UPS1"),
+			encodePatchVarint(uint64(len(rom)))...),
+			encodePatchVarint(1<<40)...)},
+		{"BPS target too big", append(append(append([]byte("BPS1"),
+			encodePatchVarint(uint64(len(rom)))...),
+			encodePatchVarint(1<<40)...),
+			encodePatchVarint(0)...)},
+		{"BPS metadata past the end", append(append(append([]byte("BPS1"),
+			encodePatchVarint(uint64(len(rom)))...),
+			encodePatchVarint(uint64(len(rom)))...),
+			encodePatchVarint(1<<63)...)},
+		{"BPS command past the end", append(append(append(append([]byte("BPS1"),
+			encodePatchVarint(uint64(len(rom)))...),
+			encodePatchVarint(uint64(len(rom)))...),
+			encodePatchVarint(0)...),
+			encodePatchVarint(1<<63)...)},
+		{"UPS varint overflow", append([]byte("UPS1"), overflow...)},
+		{"BPS varint overflow", append([]byte("BPS1"), overflow...)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			patch := appendPatchCRCs(tc.patch, rom, nil)
+			_, err := applyPatch(rom, patch)
+			assert.Error(t, err)
+		})
+	}
+
+	patch := append([]byte("UPS1"), encodePatchVarint(uint64(len(rom)))...)
+	patch = append(patch, encodePatchVarint(1<<40)...)
+	_, err := applyPatch(rom, appendPatchCRCs(patch, rom, nil))
+	assert.ErrorIs(t, err, ErrBadPatch)
+
+	_, err = applyPatch(rom, appendPatchCRCs(append([]byte("BPS1"), overflow...), rom, nil))
+	assert.ErrorIs(t, err, ErrBadPatch)
+}
@@ -0,0 +1,168 @@
+package gamebert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Rewinder keeps a history of save states to step back through. Only the
+// newest snapshot is kept whole. Each older one is stored as the difference
+// from the one after it, which is mostly zeroes between nearby frames and
+// compresses well. Forgetting the oldest snapshot is then just dropping
+// its delta.
+type Rewinder struct {
+	m *Machine
+
+	// Frames between snapshots
+	interval int
+	frames   int
+
+	latest []byte
+
+	// A ring of deltas, oldest first. Applying a delta to the snapshot
+	// after it gives back the snapshot it stands for.
+	deltas [][]byte
+	start  int
+	count  int
+}
+
+// Frames between snapshots. Rewinding steps back one snapshot a frame, so
+// this also sets how fast it goes.
+const rewindInterval = 4
+
+// NewRewinder keeps up to seconds of history for m
+func NewRewinder(m *Machine, seconds int) *Rewinder {
+	snapshots := seconds * FramesPerSecond / rewindInterval
+	if snapshots < 1 {
+		snapshots = 1
+	}
+
+	return &Rewinder{
+		m:        m,
+		interval: rewindInterval,
+		deltas:   make([][]byte, snapshots-1),
+	}
+}
+
+// Frame should be called once per frame while the game is running normally
+func (r *Rewinder) Frame() error {
+	r.frames++
+	if r.frames < r.interval {
+		return nil
+	}
+	r.frames = 0
+
+	var buf bytes.Buffer
+	if err := r.m.SaveState(&buf); err != nil {
+		return err
+	}
+	state := buf.Bytes()
+
+	if r.latest != nil && len(r.deltas) > 0 {
+		if r.count == len(r.deltas) {
+			// Full, so forget the oldest
+			r.start = (r.start + 1) % len(r.deltas)
+			r.count--
+		}
+		r.deltas[(r.start+r.count)%len(r.deltas)] = encodeDelta(state, r.latest)
+		r.count++
+	}
+	r.latest = state
+	return nil
+}
+
+// Rewind restores the newest snapshot and forgets it, so that the next call
+// goes back further. It stops at the oldest one. It returns false if there
+// is no history yet.
+func (r *Rewinder) Rewind() (bool, error) {
+	if r.latest == nil {
+		return false, nil
+	}
+
+	if err := r.m.LoadState(bytes.NewReader(r.latest)); err != nil {
+		return false, err
+	}
+	r.frames = 0
+
+	if r.count > 0 {
+		newest := (r.start + r.count - 1) % len(r.deltas)
+		prev, err := applyDelta(r.latest, r.deltas[newest])
+		if err != nil {
+			return false, err
+		}
+		r.deltas[newest] = nil
+		r.count--
+		r.latest = prev
+	}
+	return true, nil
+}
+
+// size is roughly how much memory the history takes
+func (r *Rewinder) size() int {
+	n := len(r.latest)
+	for i := 0; i < r.count; i++ {
+		n += len(r.deltas[(r.start+i)%len(r.deltas)])
+	}
+	return n
+}
+
+// encodeDelta XORs two equally sized states and run-length encodes the
+// result as pairs of a run of zeroes and a run of literal bytes, each
+// prefixed by its length.
+func encodeDelta(from, to []byte) []byte {
+	var out []byte
+	i := 0
+	for i < len(to) {
+		zeroes := 0
+		for i+zeroes < len(to) && from[i+zeroes] == to[i+zeroes] {
+			zeroes++
+		}
+		i += zeroes
+
+		literals := 0
+		for i+literals < len(to) && from[i+literals] != to[i+literals] {
+			literals++
+		}
+
+		out = binary.AppendUvarint(out, uint64(zeroes))
+		out = binary.AppendUvarint(out, uint64(literals))
+		for j := i; j < i+literals; j++ {
+			out = append(out, from[j]^to[j])
+		}
+		i += literals
+	}
+	return out
+}
+
+// applyDelta undoes encodeDelta, giving back the other state
+func applyDelta(state, delta []byte) ([]byte, error) {
+	out := make([]byte, len(state))
+	copy(out, state)
+
+	errCorrupt := errors.New("Corrupt rewind delta")
+	i := 0
+	for len(delta) > 0 {
+		zeroes, n := binary.Uvarint(delta)
+		if n <= 0 {
+			return nil, errCorrupt
+		}
+		delta = delta[n:]
+		literals, n := binary.Uvarint(delta)
+		if n <= 0 {
+			return nil, errCorrupt
+		}
+		delta = delta[n:]
+
+		i += int(zeroes)
+		if i+int(literals) > len(out) || int(literals) > len(delta) {
+			return nil, errCorrupt
+		}
+		for j := 0; j < int(literals); j++ {
+			out[i+j] ^= delta[j]
+		}
+		i += int(literals)
+		delta = delta[literals:]
+	}
+	return out, nil
+}
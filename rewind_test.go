@@ -0,0 +1,94 @@
+package gamebert
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	from := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	to := []byte{1, 2, 9, 9, 5, 6, 7, 0}
+
+	delta := encodeDelta(from, to)
+	got, err := applyDelta(to, delta)
+	assert.NoError(t, err)
+	assert.Equal(t, from, got)
+
+	got, err = applyDelta(from, delta)
+	assert.NoError(t, err)
+	assert.Equal(t, to, got)
+
+	// Identical states need next to nothing
+	assert.Len(t, encodeDelta(from, from), 2)
+
+	_, err = applyDelta(from, []byte{0x80})
+	assert.Error(t, err)
+	_, err = applyDelta(from, []byte{7, 5, 1, 1, 1, 1, 1})
+	assert.Error(t, err)
+}
+
+func TestRewind(t *testing.T) {
+	mb := newStateTestMotherboard(t, 0x12)
+	m := &Machine{mb: mb}
+	r := NewRewinder(m, 1)
+
+	ok, err := r.Rewind()
+	assert.NoError(t, err)
+	assert.False(t, ok, "nothing to rewind to yet")
+
+	// Record a counter in RAM at each snapshot
+	var states [][]byte
+	for i := 0; i < 40; i++ {
+		mb.writeByte(0xC000, uint8(i))
+		assert.NoError(t, m.RunFrame())
+		assert.NoError(t, r.Frame())
+		if r.frames == 0 {
+			states = append(states, saveStateBytes(t, mb))
+		}
+	}
+	assert.Len(t, states, 10)
+
+	// Only the newest snapshot should be kept whole
+	assert.Less(t, r.size(), 2*len(states[0]))
+
+	// Step back through every snapshot, newest first
+	for i := len(states) - 1; i >= 0; i-- {
+		ok, err := r.Rewind()
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.True(t, bytes.Equal(states[i], saveStateBytes(t, mb)), "snapshot %d", i)
+	}
+
+	// Then stay on the oldest
+	ok, err = r.Rewind()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, states[0], saveStateBytes(t, mb))
+}
+
+func TestRewindForgetsOldest(t *testing.T) {
+	mb := newStateTestMotherboard(t, 0x12)
+	m := &Machine{mb: mb}
+	r := NewRewinder(m, 1)
+	capacity := FramesPerSecond / rewindInterval
+
+	for i := 0; i < 3*capacity*rewindInterval; i++ {
+		mb.writeByte(0xC000, uint8(i/rewindInterval))
+		assert.NoError(t, m.RunFrame())
+		assert.NoError(t, r.Frame())
+	}
+
+	steps := 0
+	for r.count > 0 {
+		_, err := r.Rewind()
+		assert.NoError(t, err)
+		steps++
+	}
+	assert.Equal(t, capacity-1, steps)
+
+	_, err := r.Rewind()
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(2*capacity), mb.readByte(0xC000))
+}
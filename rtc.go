@@ -0,0 +1,215 @@
+package gamebert
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const (
+	rtcSeconds = iota
+	rtcMinutes
+	rtcHours
+	rtcDayLow
+	rtcDayHigh
+	rtcRegisterCount
+)
+
+// Bits in the day-high register
+const (
+	rtcDayHighBit8  = 0
+	rtcDayHighHalt  = 6
+	rtcDayHighCarry = 7
+)
+
+// RTCClock is how an MBC3 cartridge's clock decides that a second has
+// passed
+type RTCClock int
+
+const (
+	// RTCClockHost follows the host's wall clock, like a real cartridge
+	// battery would. This is the default.
+	RTCClockHost RTCClock = iota
+	// RTCClockCycles counts emulated CPU cycles, so the clock runs at game
+	// speed and stops when the emulator is paused.
+	RTCClockCycles
+)
+
+const cyclesPerSecond = 4194304
+
+// MBC3 real-time clock
+// https://gbdev.io/pandocs/MBC3.html#the-clock-counter-registers
+type rtc struct {
+	regs    [rtcRegisterCount]uint8
+	latched [rtcRegisterCount]uint8
+
+	// The last value written to the latch register. Writing 0x00 then 0x01
+	// latches the clock.
+	lastLatchWrite uint8
+
+	clock RTCClock
+
+	// Cycles into the current second, for RTCClockCycles
+	subSecondCycles uint32
+	// The host time that regs was last brought up to date, for RTCClockHost
+	lastUpdate time.Time
+
+	now func() time.Time
+}
+
+func newRTC() *rtc {
+	rtc := &rtc{
+		lastLatchWrite: 0xFF,
+		clock:          RTCClockHost,
+		now:            time.Now,
+	}
+	rtc.lastUpdate = rtc.now()
+	return rtc
+}
+
+func (rtc *rtc) setClock(clock RTCClock) {
+	rtc.sync()
+	rtc.clock = clock
+	rtc.lastUpdate = rtc.now()
+}
+
+func (rtc *rtc) halted() bool {
+	return isBitSet8(rtc.regs[rtcDayHigh], rtcDayHighHalt)
+}
+
+// tick advances the clock by a number of emulated cycles
+func (rtc *rtc) tick(cycles uint8) {
+	if rtc.clock != RTCClockCycles || rtc.halted() {
+		return
+	}
+
+	rtc.subSecondCycles += uint32(cycles)
+	if rtc.subSecondCycles >= cyclesPerSecond {
+		rtc.subSecondCycles -= cyclesPerSecond
+		rtc.advance(1)
+	}
+}
+
+// sync brings the registers up to date with the host clock
+func (rtc *rtc) sync() {
+	if rtc.clock != RTCClockHost {
+		return
+	}
+
+	now := rtc.now()
+	elapsed := now.Sub(rtc.lastUpdate)
+	if elapsed < time.Second {
+		return
+	}
+
+	seconds := uint64(elapsed / time.Second)
+	rtc.lastUpdate = rtc.lastUpdate.Add(time.Duration(seconds) * time.Second)
+	if !rtc.halted() {
+		rtc.advance(seconds)
+	}
+}
+
+// advance moves the clock forward, carrying into minutes, hours and days
+// and setting the day carry bit if the 9-bit day counter overflows.
+func (rtc *rtc) advance(seconds uint64) {
+	total := uint64(rtc.regs[rtcSeconds]) + seconds
+	rtc.regs[rtcSeconds] = uint8(total % 60)
+
+	total = uint64(rtc.regs[rtcMinutes]) + total/60
+	rtc.regs[rtcMinutes] = uint8(total % 60)
+
+	total = uint64(rtc.regs[rtcHours]) + total/60
+	rtc.regs[rtcHours] = uint8(total % 24)
+
+	days := uint64(rtc.days()) + total/24
+	if days > 0x1FF {
+		rtc.regs[rtcDayHigh] |= 1 << rtcDayHighCarry
+		days &= 0x1FF
+	}
+	rtc.setDays(uint16(days))
+}
+
+func (rtc *rtc) days() uint16 {
+	return uint16(rtc.regs[rtcDayHigh]&0x01)<<8 | uint16(rtc.regs[rtcDayLow])
+}
+
+func (rtc *rtc) setDays(days uint16) {
+	rtc.regs[rtcDayLow] = uint8(days)
+	rtc.regs[rtcDayHigh] = rtc.regs[rtcDayHigh]&0xFE | uint8(days>>8)&0x01
+}
+
+func (rtc *rtc) writeLatch(val uint8) {
+	if rtc.lastLatchWrite == 0x00 && val == 0x01 {
+		rtc.sync()
+		rtc.latched = rtc.regs
+	}
+	rtc.lastLatchWrite = val
+}
+
+// The games only ever see the latched copy of the registers
+func (rtc *rtc) read(reg uint8) uint8 {
+	return rtc.latched[reg] | rtcUnusedBits[reg]
+}
+
+func (rtc *rtc) write(reg uint8, val uint8) {
+	rtc.sync()
+
+	val &^= rtcUnusedBits[reg]
+	if reg == rtcSeconds {
+		rtc.subSecondCycles = 0
+		rtc.lastUpdate = rtc.now()
+	}
+	rtc.regs[reg] = val
+	rtc.latched[reg] = val
+}
+
+// Bits that don't exist in each register, which read back as 1
+var rtcUnusedBits = [rtcRegisterCount]uint8{0xC0, 0xC0, 0xE0, 0x00, 0x3E}
+
+// RTC state is appended to the .sav file in the format used by VBA-M, BGB
+// and most other emulators: the live then the latched registers as 32-bit
+// little-endian ints, followed by a 64-bit UNIX timestamp.
+const (
+	rtcFooterSize       = 48
+	rtcFooterSizeLegacy = 44 // Same, with a 32-bit timestamp
+)
+
+func (rtc *rtc) footer() []byte {
+	rtc.sync()
+
+	buf := make([]byte, rtcFooterSize)
+	for i := 0; i < rtcRegisterCount; i++ {
+		binary.LittleEndian.PutUint32(buf[i*4:], uint32(rtc.regs[i]))
+		binary.LittleEndian.PutUint32(buf[20+i*4:], uint32(rtc.latched[i]))
+	}
+	binary.LittleEndian.PutUint64(buf[40:], uint64(rtc.now().Unix()))
+
+	return buf
+}
+
+// loadFooter restores the clock and then catches it up with the time that
+// has passed since it was saved.
+func (rtc *rtc) loadFooter(buf []byte) error {
+	var savedAt int64
+	switch len(buf) {
+	case rtcFooterSize:
+		savedAt = int64(binary.LittleEndian.Uint64(buf[40:]))
+	case rtcFooterSizeLegacy:
+		savedAt = int64(binary.LittleEndian.Uint32(buf[40:]))
+	default:
+		return fmt.Errorf("Unexpected RTC footer size: %d bytes", len(buf))
+	}
+
+	for i := 0; i < rtcRegisterCount; i++ {
+		rtc.regs[i] = uint8(binary.LittleEndian.Uint32(buf[i*4:])) &^ rtcUnusedBits[i]
+		rtc.latched[i] = uint8(binary.LittleEndian.Uint32(buf[20+i*4:])) &^ rtcUnusedBits[i]
+	}
+
+	now := rtc.now()
+	rtc.lastUpdate = now
+	if elapsed := now.Unix() - savedAt; elapsed > 0 && !rtc.halted() {
+		rtc.advance(uint64(elapsed))
+	}
+
+	return nil
+}
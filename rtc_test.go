@@ -0,0 +1,84 @@
+package gamebert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRTC(now *time.Time) *rtc {
+	rtc := newRTC()
+	rtc.now = func() time.Time { return *now }
+	rtc.lastUpdate = *now
+	return rtc
+}
+
+func TestRTCLatch(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	rtc := newTestRTC(&now)
+
+	now = now.Add(61 * time.Second)
+	// Nothing is visible until the clock is latched
+	assert.Equal(t, uint8(0xC0), rtc.read(rtcSeconds))
+
+	rtc.writeLatch(0x01)
+	assert.Equal(t, uint8(0xC0), rtc.read(rtcSeconds))
+
+	rtc.writeLatch(0x00)
+	rtc.writeLatch(0x01)
+	assert.Equal(t, uint8(0xC1), rtc.read(rtcSeconds))
+	assert.Equal(t, uint8(0xC1), rtc.read(rtcMinutes))
+}
+
+func TestRTCDayCarry(t *testing.T) {
+	rtc := newRTC()
+	rtc.setClock(RTCClockCycles)
+	rtc.setDays(0x1FF)
+	rtc.regs[rtcHours] = 23
+	rtc.regs[rtcMinutes] = 59
+	rtc.regs[rtcSeconds] = 59
+
+	for i := 0; i < cyclesPerSecond/4; i++ {
+		rtc.tick(4)
+	}
+
+	assert.Equal(t, uint16(0), rtc.days())
+	assert.True(t, isBitSet8(rtc.regs[rtcDayHigh], rtcDayHighCarry))
+	assert.Equal(t, uint8(0), rtc.regs[rtcSeconds])
+}
+
+func TestRTCHalt(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	rtc := newTestRTC(&now)
+
+	rtc.write(rtcDayHigh, 1<<rtcDayHighHalt)
+	now = now.Add(time.Hour)
+	rtc.write(rtcDayHigh, 0)
+	now = now.Add(2 * time.Second)
+
+	rtc.writeLatch(0x00)
+	rtc.writeLatch(0x01)
+	assert.Equal(t, uint8(0xC2), rtc.read(rtcSeconds))
+	assert.Equal(t, uint8(0xC0), rtc.read(rtcMinutes))
+}
+
+func TestRTCFooterRoundTrip(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	rtc := newTestRTC(&now)
+	rtc.write(rtcHours, 5)
+
+	footer := rtc.footer()
+	assert.Len(t, footer, rtcFooterSize)
+
+	// Reload a day and a minute later
+	now = now.Add(24*time.Hour + time.Minute)
+	rtc2 := newTestRTC(&now)
+	assert.NoError(t, rtc2.loadFooter(footer))
+
+	assert.Equal(t, uint8(5), rtc2.regs[rtcHours])
+	assert.Equal(t, uint8(1), rtc2.regs[rtcMinutes])
+	assert.Equal(t, uint16(1), rtc2.days())
+
+	assert.Error(t, rtc2.loadFooter(footer[:10]))
+}
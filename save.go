@@ -0,0 +1,146 @@
+package gamebert
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Cartridges with RAM that can be persisted implement batteryRAM. Whether
+// the RAM actually survives power-off depends on the header, which is
+// recorded in battery.present.
+type batteryRAM interface {
+	batteryState() *battery
+	ramData() []byte
+}
+
+// Cartridges that keep extra state beside RAM (such as an RTC) append it to
+// the save file as a footer.
+type saveFooter interface {
+	saveFooter() []byte
+	loadSaveFooter([]byte) error
+}
+
+type battery struct {
+	present bool
+
+	// RAM has been written to since the last flush
+	dirty bool
+	// The game has disabled RAM after writing to it, which is a good sign
+	// that it has finished saving.
+	flushRequested bool
+}
+
+func (b *battery) batteryState() *battery {
+	return b
+}
+
+func (b *battery) ramWritten() {
+	b.dirty = true
+}
+
+func (b *battery) ramDisabled() {
+	if b.dirty {
+		b.flushRequested = true
+	}
+}
+
+// How often to flush dirty RAM when the game never disables it
+const saveFlushInterval = 5 * time.Second
+
+// SaveFile persists battery-backed cartridge RAM to a .sav file beside the
+// ROM. The file is the raw contents of RAM, which is what most other
+// emulators use too.
+type SaveFile struct {
+	path      string
+	cart      cartridge
+	ram       batteryRAM
+	lastFlush time.Time
+}
+
+// OpenSaveFile loads any existing save for the ROM at romPath into m's
+// cartridge. It returns nil if the cartridge has no battery.
+func OpenSaveFile(romPath string, m *Machine) (*SaveFile, error) {
+	return openSaveFile(romPath, m.mb.cart)
+}
+
+func openSaveFile(romPath string, cart cartridge) (*SaveFile, error) {
+	b, ok := cart.(batteryRAM)
+	if !ok || !b.batteryState().present {
+		return nil, nil
+	}
+	if len(b.ramData()) == 0 && len(footer(cart)) == 0 {
+		return nil, nil
+	}
+
+	s := &SaveFile{
+		path:      savePath(romPath),
+		cart:      cart,
+		ram:       b,
+		lastFlush: time.Now(),
+	}
+
+	data, err := ioutil.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	ram := b.ramData()
+	copy(ram, data)
+
+	if f, ok := cart.(saveFooter); ok && len(data) > len(ram) {
+		if err := f.loadSaveFooter(data[len(ram):]); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func footer(cart cartridge) []byte {
+	if f, ok := cart.(saveFooter); ok {
+		return f.saveFooter()
+	}
+	return nil
+}
+
+func savePath(romPath string) string {
+	return romBasePath(romPath) + ".sav"
+}
+
+// Tick should be called once per frame. It flushes RAM if the game looks
+// like it has finished saving, or if RAM has been dirty for a while.
+func (s *SaveFile) Tick() error {
+	b := s.ram.batteryState()
+	if b.flushRequested || (b.dirty && time.Since(s.lastFlush) > saveFlushInterval) {
+		return s.Flush()
+	}
+	return nil
+}
+
+func (s *SaveFile) Flush() error {
+	b := s.ram.batteryState()
+
+	// Write to a temporary file first so that a crash mid-write can't
+	// corrupt an existing save.
+	data := append([]byte{}, s.ram.ramData()...)
+	data = append(data, footer(s.cart)...)
+
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return err
+	}
+
+	b.dirty = false
+	b.flushRequested = false
+	s.lastFlush = time.Now()
+
+	return nil
+}
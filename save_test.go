@@ -0,0 +1,52 @@
+package gamebert
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveFileRoundTrip(t *testing.T) {
+	romPath := filepath.Join(t.TempDir(), "game.gb")
+	rom := makeROM(0x10000, 0x03, 0x01, 0x02, "MBC1")
+
+	cart, err := newCartridgeFromData(rom)
+	assert.NoError(t, err)
+
+	save, err := openSaveFile(romPath, cart)
+	assert.NoError(t, err)
+	assert.NotNil(t, save)
+
+	cart.write(0x0000, 0x0A)
+	cart.write(0xA123, 0x42)
+	assert.NoError(t, save.Tick())
+	assert.NoFileExists(t, savePath(romPath))
+
+	// Disabling RAM after a write should trigger a flush
+	cart.write(0x0000, 0x00)
+	assert.NoError(t, save.Tick())
+
+	data, err := ioutil.ReadFile(savePath(romPath))
+	assert.NoError(t, err)
+	assert.Len(t, data, 0x2000)
+	assert.Equal(t, uint8(0x42), data[0x123])
+
+	cart2, err := newCartridgeFromData(rom)
+	assert.NoError(t, err)
+	_, err = openSaveFile(romPath, cart2)
+	assert.NoError(t, err)
+
+	cart2.write(0x0000, 0x0A)
+	assert.Equal(t, uint8(0x42), cart2.read(0xA123))
+}
+
+func TestSaveFileNoBattery(t *testing.T) {
+	cart, err := newCartridgeFromData(makeROM(0x10000, 0x02, 0x01, 0x02, "MBC1"))
+	assert.NoError(t, err)
+
+	save, err := openSaveFile(filepath.Join(t.TempDir(), "game.gb"), cart)
+	assert.NoError(t, err)
+	assert.Nil(t, save)
+}
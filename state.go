@@ -0,0 +1,306 @@
+package gamebert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Save states snapshot the whole machine. The format is a header:
+//
+//	"GBST"  magic
+//	u16     format version
+//	u8      header checksum of the ROM (0x014D)
+//	u16     global checksum of the ROM (0x014E)
+//
+// followed by each component's fields in a fixed order, little-endian.
+// Anything about the format changing means bumping saveStateVersion.
+const (
+	saveStateMagic   = "GBST"
+	saveStateVersion = 5
+)
+
+var (
+	ErrStateVersion     = errors.New("Save state is from an incompatible version of Gamebert")
+	ErrStateROMMismatch = errors.New("Save state is for a different ROM")
+)
+
+// stateful components can be saved and loaded as part of a save state
+type stateful interface {
+	syncState(s *stateSync)
+}
+
+// stateSync either saves or loads, so that each component only has to list
+// its fields once. The first error sticks, and everything after it is a
+// no-op.
+type stateSync struct {
+	w   io.Writer // when saving
+	r   io.Reader // when loading
+	err error
+}
+
+func (s *stateSync) loading() bool {
+	return s.r != nil
+}
+
+// sync saves or loads each of vals, which must be pointers to fixed-size
+// values or slices of them.
+func (s *stateSync) sync(vals ...interface{}) {
+	for _, v := range vals {
+		if s.err != nil {
+			return
+		}
+		if s.loading() {
+			s.err = binary.Read(s.r, binary.LittleEndian, v)
+		} else {
+			s.err = binary.Write(s.w, binary.LittleEndian, v)
+		}
+	}
+}
+
+func (s *stateSync) registers(regs ...*register8Bit) {
+	for _, reg := range regs {
+		s.sync(&reg.val)
+	}
+}
+
+// int goes through an int64, since int isn't a fixed size
+func (s *stateSync) int(v *int) {
+	x := int64(*v)
+	s.sync(&x)
+	*v = int(x)
+}
+
+func (s *stateSync) time(t *time.Time) {
+	nanos := t.UnixNano()
+	s.sync(&nanos)
+	*t = time.Unix(0, nanos)
+}
+
+// ram syncs a RAM segment, which may be nil if there isn't one
+func (s *stateSync) ram(ram *ramSegment) {
+	if ram != nil {
+		s.sync(ram.data)
+	}
+}
+
+// saveState writes a snapshot of the whole machine to w
+func (mb *motherboard) saveState(w io.Writer) error {
+	s := &stateSync{w: w}
+	mb.syncStateHeader(s)
+	mb.syncState(s)
+	return s.err
+}
+
+// loadState restores a snapshot written by saveState, clearing any error
+// that stopped the machine. If it fails, the machine is left as it was.
+func (mb *motherboard) loadState(r io.Reader) error {
+	var backup bytes.Buffer
+	if err := mb.saveState(&backup); err != nil {
+		return err
+	}
+
+	s := &stateSync{r: r}
+	mb.syncStateHeader(s)
+	mb.syncState(s)
+
+	if s.err != nil {
+		restore := &stateSync{r: &backup}
+		mb.syncStateHeader(restore)
+		mb.syncState(restore)
+		if restore.err != nil {
+			return fmt.Errorf("Failed to restore the machine after a bad save state (%v): %w", s.err, restore.err)
+		}
+		if errors.Is(s.err, io.EOF) || errors.Is(s.err, io.ErrUnexpectedEOF) {
+			return errors.New("Save state is truncated")
+		}
+		return s.err
+	}
+
+	mb.err = nil
+	mb.brk = nil
+	return nil
+}
+
+func (mb *motherboard) syncStateHeader(s *stateSync) {
+	magic := []byte(saveStateMagic)
+	version := uint16(saveStateVersion)
+	headerChecksum, globalChecksum := mb.headerChecksum, mb.globalChecksum
+	s.sync(magic, &version, &headerChecksum, &globalChecksum)
+
+	if s.err != nil || !s.loading() {
+		return
+	}
+
+	wantHeader, wantGlobal := mb.headerChecksum, mb.globalChecksum
+	if string(magic) != saveStateMagic {
+		s.err = errors.New("Not a save state")
+	} else if version != saveStateVersion {
+		s.err = fmt.Errorf("%w: format version %d, expected %d", ErrStateVersion, version, saveStateVersion)
+	} else if headerChecksum != wantHeader || globalChecksum != wantGlobal {
+		s.err = fmt.Errorf("%w: its checksum is %04X, this ROM's is %04X", ErrStateROMMismatch, globalChecksum, wantGlobal)
+	}
+}
+
+func (mb *motherboard) syncState(s *stateSync) {
+	mb.cpu.syncState(s)
+	mb.timer.syncState(s)
+	mb.lcd.syncState(s)
+	mb.apu.syncState(s)
+
+	s.ram(mb.internalRAM0)
+	s.ram(mb.internalRAM1)
+	s.ram(mb.nonIOInternalRAM0)
+	s.ram(mb.nonIOInternalRAM1)
+	s.ram(mb.ioPorts)
+	s.registers(mb.joypadIO.joyp)
+	s.sync(&mb.bootROMEnabled, &mb.cycles)
+
+	if s.loading() && mb.bootROMEnabled && mb.bootROM == nil {
+		s.err = errors.New("Save state was made while the boot ROM was running, but there isn't one loaded")
+	}
+
+	if cart, ok := mb.cart.(stateful); ok {
+		cart.syncState(s)
+	}
+}
+
+func (cpu *cpu) syncState(s *stateSync) {
+	s.registers(cpu.a, cpu.b, cpu.c, cpu.d, cpu.e, cpu.f, cpu.h, cpu.l)
+	s.sync(&cpu.pc.val, &cpu.sp.val)
+	s.registers(cpu.interruptsTriggered, cpu.interruptsEnabled)
+	s.sync(&cpu.masterInterruptsEnabled, &cpu.halted, &cpu.haltBug, &cpu.lockedUp, &cpu.stopped)
+}
+
+func (t *timer) syncState(s *stateSync) {
+	s.registers(t.div, t.tima, t.tma, t.tac)
+	s.sync(&t.counter, &t.divCounter, &t.timaCounter)
+}
+
+func (lcd *lcd) syncState(s *stateSync) {
+	s.registers(lcd.lcdc, lcd.stat, lcd.scy, lcd.scx, lcd.ly, lcd.lyc,
+		lcd.dma, lcd.bgp, lcd.obp0, lcd.obp1, lcd.wy, lcd.wx)
+	s.ram(lcd.vRAM)
+	s.ram(lcd.oam)
+	s.int(&lcd.clock)
+	// The screen too, so that there's something to show straight away
+	s.sync(lcd.renderer.screenBuffer.data)
+}
+
+func (apu *APU) syncState(s *stateSync) {
+	s.sync(&apu.enabled, &apu.nr50, &apu.nr51, &apu.frameSequencerStep, &apu.lastDivBit, &apu.sampleCounter)
+
+	for _, ch := range []*squareChannel{apu.ch1, apu.ch2} {
+		s.sync(&ch.regs, &ch.enabled, &ch.dutyPosition, &ch.sweepEnabled, &ch.sweepTimer, &ch.shadowFreq)
+		s.int(&ch.timer)
+		ch.length.syncState(s)
+		ch.envelope.syncState(s)
+	}
+
+	s.sync(&apu.ch3.regs, &apu.ch3.waveRAM, &apu.ch3.enabled, &apu.ch3.position)
+	s.int(&apu.ch3.timer)
+	apu.ch3.length.syncState(s)
+
+	s.sync(&apu.ch4.regs, &apu.ch4.enabled, &apu.ch4.lfsr)
+	s.int(&apu.ch4.timer)
+	apu.ch4.length.syncState(s)
+	apu.ch4.envelope.syncState(s)
+}
+
+func (l *lengthCounter) syncState(s *stateSync) {
+	s.sync(&l.counter, &l.enabled)
+}
+
+func (e *volumeEnvelope) syncState(s *stateSync) {
+	s.sync(&e.initialVolume, &e.increase, &e.period, &e.volume, &e.timer)
+}
+
+// Loading a state replaces cartridge RAM, which the save file should
+// pick up.
+func (b *battery) syncState(s *stateSync) {
+	if s.loading() {
+		b.ramWritten()
+	}
+}
+
+func (m *mbc0) syncState(s *stateSync) {
+	s.ram(m.ram)
+	m.battery.syncState(s)
+}
+
+func (m *mbc1) syncState(s *stateSync) {
+	s.sync(&m.bank1, &m.bank2, &m.mode, &m.ramEnabled)
+	s.ram(m.ram)
+	m.battery.syncState(s)
+}
+
+func (m *mbc2) syncState(s *stateSync) {
+	s.sync(&m.selectedRomBank, &m.ramEnabled)
+	s.ram(m.ram)
+	m.battery.syncState(s)
+}
+
+func (m *mbc3) syncState(s *stateSync) {
+	s.sync(&m.selectedRomBank, &m.selectedRamBank, &m.ramEnabled)
+	s.ram(m.ram)
+	if m.rtc != nil {
+		m.rtc.syncState(s)
+	}
+	m.battery.syncState(s)
+}
+
+func (m *mbc5) syncState(s *stateSync) {
+	s.sync(&m.selectedRomBank, &m.selectedRamBank, &m.ramEnabled)
+	// Through setRumble, so that the motor follows the state
+	rumbling := m.rumbling
+	s.sync(&rumbling)
+	if s.loading() && s.err == nil {
+		m.setRumble(rumbling)
+	}
+	s.ram(m.ram)
+	m.battery.syncState(s)
+}
+
+func (rtc *rtc) syncState(s *stateSync) {
+	s.sync(&rtc.regs, &rtc.latched, &rtc.lastLatchWrite, &rtc.subSecondCycles)
+	s.time(&rtc.lastUpdate)
+}
+
+// How many save state slots there are, numbered from 1
+const SaveStateSlots = 9
+
+// StatePath is where a save state slot lives, beside the ROM
+func StatePath(romPath string, slot int) string {
+	return fmt.Sprintf("%s.ss%d", romBasePath(romPath), slot)
+}
+
+// SaveStateFile saves a state to fpath, such as a slot's StatePath
+func (m *Machine) SaveStateFile(fpath string) error {
+	var buf bytes.Buffer
+	if err := m.SaveState(&buf); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that a crash can't leave a
+	// half-written state behind.
+	tmpPath := fpath + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, fpath)
+}
+
+// LoadStateFile loads a state saved by SaveStateFile
+func (m *Machine) LoadStateFile(fpath string) error {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return m.LoadState(f)
+}
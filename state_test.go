@@ -0,0 +1,158 @@
+package gamebert
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newStateTestMotherboard(t *testing.T, globalChecksum uint8) *motherboard {
+	rom := makeROM(0x10000, 0x13, 0x01, 0x03, "MBC3")
+	rom[0x014F] = globalChecksum
+	// Spin at the entry point, with JR -2
+	rom[0x0100] = 0x18
+	rom[0x0101] = 0xFE
+
+	cart, err := newCartridgeFromData(rom)
+	assert.NoError(t, err)
+	return newMotherboard(cart)
+}
+
+func runStateTestCycles(mb *motherboard, cycles uint64) {
+	end := mb.cycles + cycles
+	for mb.cycles < end {
+		mb.tick()
+	}
+}
+
+func saveStateBytes(t *testing.T, mb *motherboard) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, mb.saveState(&buf))
+	return buf.Bytes()
+}
+
+func TestSaveStateRoundTrip(t *testing.T) {
+	mb := newStateTestMotherboard(t, 0x12)
+
+	// Switch some banks and poke at memory so there's state worth keeping
+	mb.writeByte(0x0000, 0x0A)
+	mb.writeByte(0x2000, 0x03)
+	mb.writeByte(0xA010, 0x42)
+	mb.writeByte(0xC123, 0x99)
+	mb.writeByte(0xFF26, 0x80)
+	mb.writeByte(0xFF12, 0xF0)
+	mb.writeByte(0xFF14, 0x80)
+	runStateTestCycles(mb, 10000)
+
+	state := saveStateBytes(t, mb)
+	runStateTestCycles(mb, 20000)
+	want := saveStateBytes(t, mb)
+
+	mb.writeByte(0xC123, 0x00)
+	mb.writeByte(0x2000, 0x01)
+
+	assert.NoError(t, mb.loadState(bytes.NewReader(state)))
+	assert.Equal(t, uint8(0x99), mb.readByte(0xC123))
+	assert.Equal(t, uint8(0x42), mb.readByte(0xA010))
+	assert.Equal(t, uint8(3), mb.readByte(0x5000))
+	assert.Equal(t, state, saveStateBytes(t, mb))
+
+	// Running on from a loaded state should be indistinguishable
+	runStateTestCycles(mb, 20000)
+	assert.Equal(t, want, saveStateBytes(t, mb))
+}
+
+func TestLoadStateWrongROM(t *testing.T) {
+	state := saveStateBytes(t, newStateTestMotherboard(t, 0x12))
+
+	mb := newStateTestMotherboard(t, 0x34)
+	err := mb.loadState(bytes.NewReader(state))
+	assert.ErrorIs(t, err, ErrStateROMMismatch)
+}
+
+func TestLoadStateVersion(t *testing.T) {
+	mb := newStateTestMotherboard(t, 0x12)
+	state := saveStateBytes(t, mb)
+	state[len(saveStateMagic)]++
+
+	err := mb.loadState(bytes.NewReader(state))
+	assert.ErrorIs(t, err, ErrStateVersion)
+
+	err = mb.loadState(bytes.NewReader([]byte("not a state at all")))
+	assert.Error(t, err)
+}
+
+func TestLoadStateTruncated(t *testing.T) {
+	mb := newStateTestMotherboard(t, 0x12)
+	runStateTestCycles(mb, 10000)
+	state := saveStateBytes(t, mb)
+
+	mb.writeByte(0xC000, 0x55)
+	runStateTestCycles(mb, 10000)
+	before := saveStateBytes(t, mb)
+
+	// The machine should be left as it was
+	err := mb.loadState(bytes.NewReader(state[:len(state)/2]))
+	assert.Error(t, err)
+	assert.Equal(t, before, saveStateBytes(t, mb))
+}
+
+func TestLoadStateBankedHeader(t *testing.T) {
+	rom := makeROM(0x100000, 0x01, 0x05, 0x00, "MBC1")
+	rom[0x014F] = 0x12
+	rom[0x0100] = 0x18
+	rom[0x0101] = 0xFE
+	cart, err := newCartridgeFromData(rom)
+	assert.NoError(t, err)
+	mb := newMotherboard(cart)
+
+	// In mode 1, bank2 swaps another bank into 0x0000-0x3FFF, header and all
+	mb.writeByte(0x6000, 0x01)
+	mb.writeByte(0x4000, 0x01)
+	assert.Equal(t, uint8(0x00), mb.readByte(0x014F))
+	state := saveStateBytes(t, mb)
+
+	mb.writeByte(0x6000, 0x00)
+	assert.NoError(t, mb.loadState(bytes.NewReader(state)))
+	assert.Equal(t, uint8(0x00), mb.readByte(0x014F))
+}
+
+func TestLoadStateRumble(t *testing.T) {
+	rom := makeROM(0x10000, 0x1E, 0x01, 0x02, "MBC5")
+	rom[0x0100] = 0x18
+	rom[0x0101] = 0xFE
+	cart, err := newCartridgeFromData(rom)
+	assert.NoError(t, err)
+
+	var calls []bool
+	mb := newMotherboard(cart, WithRumble(func(on bool) {
+		calls = append(calls, on)
+	}))
+
+	mb.writeByte(0x4000, 0x08)
+	state := saveStateBytes(t, mb)
+	mb.writeByte(0x4000, 0x00)
+
+	// The motor comes back on with the state, and only changes get reported
+	assert.NoError(t, mb.loadState(bytes.NewReader(state)))
+	assert.NoError(t, mb.loadState(bytes.NewReader(state)))
+	assert.Equal(t, []bool{true, false, true}, calls)
+}
+
+func TestStateFileSlots(t *testing.T) {
+	romPath := filepath.Join(t.TempDir(), "game.gb")
+	assert.Equal(t, filepath.Join(filepath.Dir(romPath), "game.ss3"), StatePath(romPath, 3))
+
+	mb := newStateTestMotherboard(t, 0x12)
+	mb.writeByte(0xC000, 0x77)
+	m := &Machine{mb: mb}
+	assert.NoError(t, m.SaveStateFile(StatePath(romPath, 1)))
+
+	mb.writeByte(0xC000, 0x00)
+	assert.NoError(t, m.LoadStateFile(StatePath(romPath, 1)))
+	assert.Equal(t, uint8(0x77), mb.readByte(0xC000))
+
+	assert.Error(t, m.LoadStateFile(StatePath(romPath, 2)))
+}
@@ -1,10 +1,10 @@
-package main
+package gamebert
 
-type Timer struct {
-	div  *Register8Bit // 0xFF04
-	tima *Register8Bit // 0xFF05
-	tma  *Register8Bit // 0xFF06
-	tac  *Register8Bit // 0xFF07
+type timer struct {
+	div  *register8Bit // 0xFF04
+	tima *register8Bit // 0xFF05
+	tma  *register8Bit // 0xFF06
+	tac  *register8Bit // 0xFF07
 
 	counter uint16
 
@@ -12,22 +12,22 @@ type Timer struct {
 	timaCounter uint16
 }
 
-func NewTimer() *Timer {
-	return &Timer{
-		div:  &Register8Bit{name: "div"},
-		tima: &Register8Bit{name: "tima"},
-		tma:  &Register8Bit{name: "tma"},
-		tac:  &Register8Bit{name: "tac"},
+func newTimer() *timer {
+	return &timer{
+		div:  &register8Bit{name: "div"},
+		tima: &register8Bit{name: "tima"},
+		tma:  &register8Bit{name: "tma"},
+		tac:  &register8Bit{name: "tac"},
 
 		counter: 0,
 	}
 }
 
-func (t *Timer) tick(cycles uint8) bool {
+func (t *timer) tick(cycles uint8) bool {
 	t.divCounter += cycles
 	// If divCounter has overflowed
 	if t.divCounter < cycles {
-		t.div.inc(cycles)
+		t.div.inc(1)
 	}
 
 	if isBitSet8(t.tac.read(), 2) {
@@ -53,6 +53,6 @@ func (t *Timer) tick(cycles uint8) bool {
 
 var tacFreqs = []uint16{1024, 16, 64, 256}
 
-func (t Timer) tacFreq() uint16 {
+func (t timer) tacFreq() uint16 {
 	return tacFreqs[t.tac.read()&0b11]
 }
@@ -1,4 +1,6 @@
-package main
+package gamebert
+
+import "fmt"
 
 func chunk16(x uint16) (uint8, uint8) {
 	hi := uint8((x >> 8) & 0xFF)
@@ -98,32 +100,39 @@ func shiftR8(x uint8) (uint8, bool) {
 	return rot, oldBit0
 }
 
-type Buffer2D struct {
+type buffer2D struct {
 	data []uint8
 	rows uint8
 	cols uint8
 }
 
-func NewBuffer2D(rows, cols uint8) *Buffer2D {
-	return &Buffer2D{
+func newBuffer2D(rows, cols uint8) *buffer2D {
+	return &buffer2D{
 		rows: rows,
 		cols: cols,
 		data: make([]uint8, uint16(rows)*uint16(cols)),
 	}
 }
 
-func (b Buffer2D) read(x, y uint8) uint8 {
+func (b buffer2D) read(x, y uint8) uint8 {
 	return b.data[b.idx(x, y)]
 }
 
-func (b *Buffer2D) write(x, y uint8, val uint8) {
+func (b *buffer2D) write(x, y uint8, val uint8) {
 	b.data[b.idx(x, y)] = val
 }
 
-func (b Buffer2D) idx(x, y uint8) uint16 {
+func (b buffer2D) idx(x, y uint8) uint16 {
 	return uint16(x) + (uint16(b.cols) * uint16(y))
 }
 
 func clearBit(x uint8, bitN int) uint8 {
 	return x & ^(uint8(1) << bitN)
 }
+
+func hex8(x uint8) string {
+	return fmt.Sprintf("%02X", x)
+}
+func hex16(x uint16) string {
+	return fmt.Sprintf("%04X", x)
+}
@@ -0,0 +1,80 @@
+package gamebert
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+)
+
+// WAVWriter records 16-bit stereo PCM to a .wav file. The header is written
+// up front with empty sizes, which are filled in on Close.
+// http://soundfile.sapp.org/doc/WaveFormat/
+type WAVWriter struct {
+	f          *os.File
+	w          *bufio.Writer
+	sampleRate int
+	dataSize   uint32
+}
+
+const wavHeaderSize = 44
+
+func CreateWAVFile(fpath string, sampleRate int) (*WAVWriter, error) {
+	f, err := os.Create(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WAVWriter{
+		f:          f,
+		w:          bufio.NewWriter(f),
+		sampleRate: sampleRate,
+	}
+	if _, err := w.w.Write(w.header()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *WAVWriter) header() []byte {
+	const channels = 2
+	const bitsPerSample = 16
+	blockAlign := channels * bitsPerSample / 8
+
+	h := make([]byte, 0, wavHeaderSize)
+	h = append(h, "RIFF"...)
+	h = binary.LittleEndian.AppendUint32(h, 36+w.dataSize)
+	h = append(h, "WAVE"...)
+
+	h = append(h, "fmt "...)
+	h = binary.LittleEndian.AppendUint32(h, 16)
+	h = binary.LittleEndian.AppendUint16(h, 1) // PCM
+	h = binary.LittleEndian.AppendUint16(h, channels)
+	h = binary.LittleEndian.AppendUint32(h, uint32(w.sampleRate))
+	h = binary.LittleEndian.AppendUint32(h, uint32(w.sampleRate*blockAlign))
+	h = binary.LittleEndian.AppendUint16(h, uint16(blockAlign))
+	h = binary.LittleEndian.AppendUint16(h, bitsPerSample)
+
+	h = append(h, "data"...)
+	h = binary.LittleEndian.AppendUint32(h, w.dataSize)
+	return h
+}
+
+func (w *WAVWriter) WriteSamples(samples []int16) error {
+	if err := binary.Write(w.w, binary.LittleEndian, samples); err != nil {
+		return err
+	}
+	w.dataSize += uint32(len(samples) * 2)
+	return nil
+}
+
+func (w *WAVWriter) Close() error {
+	err := w.w.Flush()
+	if err == nil {
+		_, err = w.f.WriteAt(w.header(), 0)
+	}
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,34 @@
+package gamebert
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAVWriter(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "out.wav")
+
+	w, err := CreateWAVFile(fpath, 44100)
+	assert.Nil(t, err)
+	assert.Nil(t, w.WriteSamples([]int16{1, 2}))
+	assert.Nil(t, w.WriteSamples([]int16{-1, -2}))
+	assert.Nil(t, w.Close())
+
+	data, err := ioutil.ReadFile(fpath)
+	assert.Nil(t, err)
+	assert.Equal(t, wavHeaderSize+8, len(data))
+
+	assert.Equal(t, "RIFF", string(data[0:4]))
+	assert.Equal(t, uint32(36+8), binary.LittleEndian.Uint32(data[4:]))
+	assert.Equal(t, "WAVE", string(data[8:12]))
+	assert.Equal(t, uint16(2), binary.LittleEndian.Uint16(data[22:]))
+	assert.Equal(t, uint32(44100), binary.LittleEndian.Uint32(data[24:]))
+	assert.Equal(t, uint32(44100*4), binary.LittleEndian.Uint32(data[28:]))
+	assert.Equal(t, "data", string(data[36:40]))
+	assert.Equal(t, uint32(8), binary.LittleEndian.Uint32(data[40:]))
+	assert.Equal(t, []byte{1, 0, 2, 0, 0xFF, 0xFF, 0xFE, 0xFF}, data[44:])
+}